@@ -0,0 +1,42 @@
+package beatles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Writes to a temporary file alongside path and renames it into place, so
+// readers see either the old contents or the new, never a partial write.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(temp.Name())
+		}
+	}()
+
+	if _, err = temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err = temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err = temp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chmod(temp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,305 @@
+package beatles
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const BundleVersion = 1
+
+const BundleManifestName = "manifest.json"
+
+type BundleManifest struct {
+	Version int
+	Created time.Time
+	Entries []BundleEntry
+}
+
+type BundleEntry struct {
+	Name     string
+	Kind     string
+	ID       string
+	Size     int64
+	ModTime  time.Time
+	Checksum string
+}
+
+// Bundles are gzipped tarballs of cache files with the manifest as the first
+// entry, so an importer can check the version before reading anything else.
+func CacheExport(args []string) error {
+	var output string
+	var filter CacheFilter
+	fs := flag.NewFlagSet("cache export", flag.ExitOnError)
+	fs.StringVar(&output, "output", "beatles-cache.tar.gz", "bundle to write")
+	filter.AddFlags(fs)
+	fs.Parse(args)
+
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	entries = filter.Apply(entries)
+
+	manifest := BundleManifest{
+		Version: BundleVersion,
+		Created: time.Now().UTC(),
+		Entries: make([]BundleEntry, 0),
+	}
+
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		file, err := ioutil.ReadFile(entry.Path)
+		if err != nil {
+			return err
+		}
+
+		decoded, err := decodeCacheFile(file)
+		if err == nil {
+			err = entry.Kind.Decode(decoded)
+		}
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", entry.Path, err)
+			continue
+		}
+
+		name := filepath.Base(entry.Path)
+		files[name] = file
+		manifest.Entries = append(manifest.Entries, BundleEntry{
+			Name:     name,
+			Kind:     entry.Kind.Name,
+			ID:       entry.ID,
+			Size:     int64(len(file)),
+			ModTime:  entry.ModTime.UTC(),
+			Checksum: checksum(file),
+		})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	gz := gzip.NewWriter(temp)
+	tw := tar.NewWriter(gz)
+
+	err = writeTarFile(tw, BundleManifestName, manifest.Created, manifestData)
+	if err != nil {
+		temp.Close()
+		return err
+	}
+
+	for _, entry := range manifest.Entries {
+		err = writeTarFile(tw, entry.Name, entry.ModTime, files[entry.Name])
+		if err != nil {
+			temp.Close()
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), output); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d entries to %s\n", len(manifest.Entries), output)
+
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+	return err
+}
+
+// Entries are merged into the cache, on conflict whichever entry was written
+// most recently is kept.
+func CacheImport(args []string) error {
+	var dry bool
+	fs := flag.NewFlagSet("cache import", flag.ExitOnError)
+	fs.BoolVar(&dry, "dry", false, "only list what would be imported")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("Usage: beatles cache import [--dry] <bundle>")
+	}
+
+	path := fs.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", path, err)
+	}
+
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", path, err)
+	}
+
+	if header.Name != BundleManifestName {
+		return fmt.Errorf("Error reading %s: expected %s first, found %s", path, BundleManifestName, header.Name)
+	}
+
+	manifestData, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return err
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("Error unmarshalling manifest: %v", err)
+	}
+
+	if manifest.Version > BundleVersion {
+		return fmt.Errorf("Bundle version %d is newer than supported (%d)", manifest.Version, BundleVersion)
+	}
+
+	expected := make(map[string]BundleEntry)
+	for _, entry := range manifest.Entries {
+		expected[entry.Name] = entry
+	}
+
+	// Everything's read and checked before anything's written, a truncated or
+	// damaged bundle isn't partly merged.
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", path, err)
+		}
+
+		entry, ok := expected[header.Name]
+		if !ok {
+			return fmt.Errorf("Error reading %s: %s isn't in the manifest", path, header.Name)
+		}
+		if _, ok := files[entry.Name]; ok {
+			return fmt.Errorf("Error reading %s: %s is in the bundle twice", path, entry.Name)
+		}
+
+		kind, _ := ParseCacheFileName(entry.Name)
+		if kind == nil {
+			return fmt.Errorf("Error reading %s: %s isn't a cache entry", path, entry.Name)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", path, err)
+		}
+
+		if actual := checksum(data); actual != entry.Checksum {
+			return fmt.Errorf("Error reading %s: checksum mismatch on %s", path, entry.Name)
+		}
+
+		decoded, err := decodeCacheFile(data)
+		if err == nil {
+			err = kind.Decode(decoded)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %s: %v", path, entry.Name, err)
+		}
+
+		files[entry.Name] = data
+	}
+
+	for _, entry := range manifest.Entries {
+		if _, ok := files[entry.Name]; !ok {
+			return fmt.Errorf("Error reading %s: %s is in the manifest but not the bundle", path, entry.Name)
+		}
+	}
+
+	if !dry {
+		if err := os.MkdirAll(CacheDirectory, 0755); err != nil {
+			return err
+		}
+	}
+
+	imported := 0
+	skipped := 0
+
+	for _, entry := range manifest.Entries {
+		cachedFile := filepath.Join(CacheDirectory, entry.Name)
+		if info, err := os.Stat(cachedFile); err == nil && !info.ModTime().Before(entry.ModTime) {
+			skipped += 1
+			continue
+		}
+
+		if dry {
+			fmt.Printf("Would import %s\n", cachedFile)
+			imported += 1
+			continue
+		}
+
+		if err := WriteFileAtomic(cachedFile, files[entry.Name], 0644); err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(cachedFile, entry.ModTime, entry.ModTime); err != nil {
+			return err
+		}
+
+		imported += 1
+	}
+
+	if dry {
+		fmt.Printf("Would import %d entries, keeping %d newer local entries\n", imported, skipped)
+		return nil
+	}
+
+	fmt.Printf("Imported %d entries, kept %d newer local entries\n", imported, skipped)
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package beatles
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// Caches an artist, an album and its tracks, returning the album.
+func cacheTestAlbum(t *testing.T) (*SpotifyCacher, spotify.ID) {
+	t.Helper()
+
+	track := spotify.SimpleTrack{ID: "tr-help", Name: "Help"}
+	album := spotify.FullAlbum{}
+	album.ID = "al-please"
+	album.Name = "Please"
+	album.Tracks.Tracks = []spotify.SimpleTrack{track}
+
+	writeCacheEntry(t, spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: "ar-testers", Name: "The Testers"}}, "artist-%s.json", "ar-testers")
+	writeCacheEntry(t, album, "album-%s.json", album.ID)
+	writeCacheEntry(t, album.Tracks.Tracks, "album-tracks-%s.json", album.ID)
+
+	return NewSpotifyCacher(nil, false), album.ID
+}
+
+func exportTestBundle(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := CacheExport([]string{"--output", path}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return path
+}
+
+// Copies the bundle at path, letting edit change the manifest and entries on
+// the way.
+func rewriteTestBundle(t *testing.T, path string, edit func(manifest *BundleManifest, files map[string][]byte)) string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var manifest BundleManifest
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if header.Name == BundleManifestName {
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatalf("%v", err)
+			}
+		} else {
+			files[header.Name] = data
+		}
+	}
+
+	edit(&manifest, files)
+
+	rewritten := filepath.Join(t.TempDir(), "rewritten.tar.gz")
+	out, err := os.Create(rewritten)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer out.Close()
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := writeTarFile(tw, BundleManifestName, manifest.Created, manifestData); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, entry := range manifest.Entries {
+		if data, ok := files[entry.Name]; ok {
+			if err := writeTarFile(tw, entry.Name, entry.ModTime, data); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return rewritten
+}
+
+func readTestAlbum(t *testing.T, id spotify.ID) *spotify.FullAlbum {
+	t.Helper()
+
+	var album *spotify.FullAlbum
+	ok, err := NewSpotifyCacher(nil, true).load(getFilePath("album-%s.json", id), &album)
+	if err != nil || !ok {
+		t.Fatalf("expected album %s to be cached, %v", id, err)
+	}
+	return album
+}
+
+func TestCacheBundleRoundTrip(t *testing.T) {
+	useCacheDirectory(t)
+	cacher, id := cacheTestAlbum(t)
+	bundle := exportTestBundle(t)
+
+	// The album's renamed here after the export, that's kept. The album's
+	// tracks are older here and its artist's gone, those come from the bundle.
+	album := readTestAlbum(t, id)
+	album.Name = "Please Please"
+	albumFile := getFilePath("album-%s.json", id)
+	if err := cacher.save(albumFile, album); err != nil {
+		t.Fatalf("%v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(albumFile, later, later); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tracksFile := getFilePath("album-tracks-%s.json", id)
+	if err := cacher.save(tracksFile, []spotify.SimpleTrack{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	earlier := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(tracksFile, earlier, earlier); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, entry := range entries {
+		if entry.Kind.Name == "artist" {
+			os.Remove(entry.Path)
+		}
+	}
+
+	if err := CacheImport([]string{bundle}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if name := readTestAlbum(t, id).Name; name != "Please Please" {
+		t.Errorf("expected the newer local album to be kept, got %s", name)
+	}
+	tracks, err := NewSpotifyCacher(nil, true).GetAlbumTracks(id)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(tracks) != 1 {
+		t.Errorf("expected the bundle's album tracks to replace the older local ones, got %d tracks", len(tracks))
+	}
+	after, err := ListCacheEntries()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(after) != len(entries) {
+		t.Errorf("expected the artist to be imported again, got %d of %d entries", len(after), len(entries))
+	}
+}
+
+func TestCacheImportDry(t *testing.T) {
+	useCacheDirectory(t)
+	cacheTestAlbum(t)
+	bundle := exportTestBundle(t)
+
+	directory := useCacheDirectory(t)
+	if err := CacheImport([]string{"--dry", bundle}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		t.Fatalf("expected a dry import to leave the cache alone, %v", err)
+	}
+}
+
+func TestCacheImportDamaged(t *testing.T) {
+	useCacheDirectory(t)
+	_, id := cacheTestAlbum(t)
+	bundle := exportTestBundle(t)
+	albumName := filepath.Base(getFilePath("album-%s.json", id))
+
+	for _, test := range []struct {
+		name string
+		edit func(manifest *BundleManifest, files map[string][]byte)
+	}{
+		{"tampered", func(manifest *BundleManifest, files map[string][]byte) {
+			files[albumName] = append(files[albumName], ' ')
+		}},
+		{"undecodable", func(manifest *BundleManifest, files map[string][]byte) {
+			files[albumName] = []byte(`{"checksum":"damaged","data":{}}`)
+			for i := range manifest.Entries {
+				if manifest.Entries[i].Name == albumName {
+					manifest.Entries[i].Checksum = checksum(files[albumName])
+				}
+			}
+		}},
+		{"missing an entry", func(manifest *BundleManifest, files map[string][]byte) {
+			delete(files, albumName)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			damaged := rewriteTestBundle(t, bundle, test.edit)
+
+			// Nothing's merged from a bundle that's partly bad.
+			directory := useCacheDirectory(t)
+			if err := CacheImport([]string{damaged}); err == nil {
+				t.Fatalf("expected the import to fail")
+			}
+			if _, err := os.Stat(directory); !os.IsNotExist(err) {
+				t.Fatalf("expected nothing imported, %v", err)
+			}
+		})
+	}
+}
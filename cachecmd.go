@@ -0,0 +1,474 @@
+package beatles
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+type CacheKind struct {
+	Name   string
+	Prefix string
+	Decode func(data []byte) error
+}
+
+// Ordered so that longer prefixes are matched first, album-tracks- before
+// album- for example.
+var CacheKinds = []CacheKind{
+	{"album-tracks", "album-tracks-", func(data []byte) error {
+		var v []spotify.SimpleTrack
+		return json.Unmarshal(data, &v)
+	}},
+	{"artist-albums", "artist-albums-", func(data []byte) error {
+		var v []spotify.SimpleAlbum
+		return json.Unmarshal(data, &v)
+	}},
+	{"artist", "artist-", func(data []byte) error {
+		var v spotify.FullArtist
+		return json.Unmarshal(data, &v)
+	}},
+	{"playlists", "playlists-", func(data []byte) error {
+		var v PlaylistSet
+		return json.Unmarshal(data, &v)
+	}},
+	{"playlist", "playlist-", func(data []byte) error {
+		var v CachedPlaylistTracks
+		return json.Unmarshal(data, &v)
+	}},
+	{"album", "album-", func(data []byte) error {
+		var v spotify.FullAlbum
+		return json.Unmarshal(data, &v)
+	}},
+	{"track", "track-", func(data []byte) error {
+		var v spotify.FullTrack
+		return json.Unmarshal(data, &v)
+	}},
+}
+
+func GetCacheKind(name string) *CacheKind {
+	for i, kind := range CacheKinds {
+		if kind.Name == name {
+			return &CacheKinds[i]
+		}
+	}
+	return nil
+}
+
+type CacheEntry struct {
+	Kind    *CacheKind
+	ID      string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Returns the cached JSON, verifying its checksum.
+func (ce *CacheEntry) Read() ([]byte, error) {
+	file, err := ioutil.ReadFile(ce.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeCacheFile(file)
+}
+
+func (ce *CacheEntry) Remove() error {
+	return os.Remove(ce.Path)
+}
+
+// A cache directory that doesn't exist yet is empty.
+func ListCacheEntries() (entries []*CacheEntry, err error) {
+	infos, err := ioutil.ReadDir(CacheDirectory)
+	if os.IsNotExist(err) {
+		return make([]*CacheEntry, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries = make([]*CacheEntry, 0)
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+
+		if kind, id := ParseCacheFileName(info.Name()); kind != nil {
+			entries = append(entries, &CacheEntry{
+				Kind:    kind,
+				ID:      id,
+				Path:    filepath.Join(CacheDirectory, info.Name()),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		}
+	}
+
+	return
+}
+
+func ParseCacheFileName(fileName string) (*CacheKind, string) {
+	if !strings.HasSuffix(fileName, ".json") || strings.ContainsAny(fileName, "/\\") {
+		return nil, ""
+	}
+
+	name := strings.TrimSuffix(fileName, ".json")
+	for i, kind := range CacheKinds {
+		if strings.HasPrefix(name, kind.Prefix) {
+			return &CacheKinds[i], strings.TrimPrefix(name, kind.Prefix)
+		}
+	}
+
+	return nil, ""
+}
+
+type CacheFilter struct {
+	Kind      string
+	ID        string
+	OlderThan time.Duration
+}
+
+func (cf *CacheFilter) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cf.Kind, "kind", "", "only entries of this kind (album, album-tracks, artist, artist-albums, playlist, playlists, track)")
+	fs.StringVar(&cf.ID, "id", "", "only entries with this id")
+	fs.DurationVar(&cf.OlderThan, "older-than", 0, "only entries last written longer ago than this")
+}
+
+func (cf *CacheFilter) Empty() bool {
+	return cf.Kind == "" && cf.ID == "" && cf.OlderThan == 0
+}
+
+func (cf *CacheFilter) Validate() error {
+	if cf.Kind != "" && GetCacheKind(cf.Kind) == nil {
+		return fmt.Errorf("Unknown cache kind: %s", cf.Kind)
+	}
+	return nil
+}
+
+func (cf *CacheFilter) Matches(entry *CacheEntry, now time.Time) bool {
+	if cf.Kind != "" && entry.Kind.Name != cf.Kind {
+		return false
+	}
+	if cf.ID != "" && entry.ID != cf.ID {
+		return false
+	}
+	if cf.OlderThan > 0 && now.Sub(entry.ModTime) < cf.OlderThan {
+		return false
+	}
+	return true
+}
+
+func (cf *CacheFilter) Apply(entries []*CacheEntry) []*CacheEntry {
+	now := time.Now()
+	filtered := make([]*CacheEntry, 0)
+	for _, entry := range entries {
+		if cf.Matches(entry, now) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+func RunCacheCommand(profile *Profile, args []string) error {
+	usage := "Usage: beatles cache <stats|ls|show|invalidate|verify|prune|export|import> [options]"
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	switch args[0] {
+	case "stats":
+		return CacheStats(args[1:])
+	case "ls":
+		return CacheList(args[1:])
+	case "show":
+		return CacheShow(args[1:])
+	case "invalidate":
+		return CacheInvalidate(args[1:])
+	case "verify":
+		return CacheVerify(args[1:])
+	case "prune":
+		return CachePrune(profile, args[1:])
+	case "export":
+		return CacheExport(args[1:])
+	case "import":
+		return CacheImport(args[1:])
+	}
+
+	return fmt.Errorf("Unknown cache command '%s'\n%s", args[0], usage)
+}
+
+func CacheStats(args []string) error {
+	fs := flag.NewFlagSet("cache stats", flag.ExitOnError)
+	fs.Parse(args)
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	sizes := make(map[string]int64)
+	var oldest *CacheEntry
+	var total int64
+
+	for _, entry := range entries {
+		counts[entry.Kind.Name] += 1
+		sizes[entry.Kind.Name] += entry.Size
+		total += entry.Size
+		if oldest == nil || entry.ModTime.Before(oldest.ModTime) {
+			oldest = entry
+		}
+	}
+
+	fmt.Printf("%-16s %8s %12s\n", "KIND", "ENTRIES", "BYTES")
+	for _, kind := range CacheKinds {
+		fmt.Printf("%-16s %8d %12d\n", kind.Name, counts[kind.Name], sizes[kind.Name])
+	}
+	fmt.Printf("%-16s %8d %12d\n", "total", len(entries), total)
+
+	if oldest != nil {
+		fmt.Printf("\nOldest: %s (%v)\n", oldest.Path, oldest.ModTime.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func CacheList(args []string) error {
+	var filter CacheFilter
+	fs := flag.NewFlagSet("cache ls", flag.ExitOnError)
+	filter.AddFlags(fs)
+	fs.Parse(args)
+
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	entries = filter.Apply(entries)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind.Name != entries[j].Kind.Name {
+			return entries[i].Kind.Name < entries[j].Kind.Name
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	for _, entry := range entries {
+		fmt.Printf("%-16s %-24s %10d %s\n", entry.Kind.Name, entry.ID, entry.Size, entry.ModTime.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func CacheShow(args []string) error {
+	var kind string
+	fs := flag.NewFlagSet("cache show", flag.ExitOnError)
+	fs.StringVar(&kind, "kind", "", "only entries of this kind")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("Usage: beatles cache show [--kind kind] <id>")
+	}
+
+	filter := CacheFilter{Kind: kind, ID: fs.Arg(0)}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	entries = filter.Apply(entries)
+	if len(entries) == 0 {
+		return fmt.Errorf("No cache entries for %s", filter.ID)
+	}
+
+	for _, entry := range entries {
+		data, err := entry.Read()
+		if err != nil {
+			return err
+		}
+
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return fmt.Errorf("Error formatting %s: %v", entry.Path, err)
+		}
+
+		fmt.Printf("# %s\n%s\n", entry.Path, indented.String())
+	}
+
+	return nil
+}
+
+func CacheInvalidate(args []string) error {
+	var filter CacheFilter
+	var dry bool
+	fs := flag.NewFlagSet("cache invalidate", flag.ExitOnError)
+	filter.AddFlags(fs)
+	fs.BoolVar(&dry, "dry", false, "only list the entries that would be removed")
+	fs.Parse(args)
+
+	if filter.Empty() {
+		return fmt.Errorf("Refusing to invalidate everything, specify --kind, --id or --older-than")
+	}
+
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	return removeCacheEntries(filter.Apply(entries), dry)
+}
+
+func CacheVerify(args []string) error {
+	fs := flag.NewFlagSet("cache verify", flag.ExitOnError)
+	fs.Parse(args)
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, entry := range entries {
+		data, err := entry.Read()
+		if err == nil {
+			err = entry.Kind.Decode(data)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", entry.Path, err)
+			failures += 1
+		}
+	}
+
+	fmt.Printf("Verified %d entries, %d failed\n", len(entries), failures)
+
+	if failures > 0 {
+		return fmt.Errorf("%d cache entries failed to parse", failures)
+	}
+
+	return nil
+}
+
+// Albums are referenced by an artist's album listing or by being excluded,
+// the album and album tracks of any other album are orphans. So are tracks
+// that no referenced album lists.
+func CachePrune(profile *Profile, args []string) error {
+	var dry bool
+	var excluded string
+	fs := flag.NewFlagSet("cache prune", flag.ExitOnError)
+	fs.BoolVar(&dry, "dry", false, "only list the entries that would be removed")
+	fs.StringVar(&excluded, "excluded-albums", profile.ExcludedAlbums, "comma separated albums whose tracks are excluded, kept though no artist lists them, defaults to the profile's")
+	fs.Parse(args)
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		return err
+	}
+
+	albums := make(map[string]bool)
+	for _, id := range ParseAlbumIDs(excluded) {
+		albums[string(id)] = true
+	}
+
+	for _, entry := range entries {
+		if entry.Kind.Name != "artist-albums" {
+			continue
+		}
+
+		artistAlbums := make([]spotify.SimpleAlbum, 0)
+		if err := readCacheEntry(entry, &artistAlbums); err != nil {
+			return err
+		}
+
+		for _, album := range artistAlbums {
+			albums[string(album.ID)] = true
+		}
+	}
+
+	orphans := make([]*CacheEntry, 0)
+	tracks := make(map[string]bool)
+	for _, entry := range entries {
+		switch entry.Kind.Name {
+		case "album", "album-tracks":
+			if !albums[entry.ID] {
+				orphans = append(orphans, entry)
+				continue
+			}
+		default:
+			continue
+		}
+
+		albumTracks := make([]spotify.SimpleTrack, 0)
+		if entry.Kind.Name == "album" {
+			var album spotify.FullAlbum
+			if err := readCacheEntry(entry, &album); err != nil {
+				return err
+			}
+			albumTracks = album.Tracks.Tracks
+		} else if err := readCacheEntry(entry, &albumTracks); err != nil {
+			return err
+		}
+
+		for _, track := range albumTracks {
+			tracks[string(track.ID)] = true
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Kind.Name == "track" && !tracks[entry.ID] {
+			orphans = append(orphans, entry)
+		}
+	}
+
+	return removeCacheEntries(orphans, dry)
+}
+
+func readCacheEntry(entry *CacheEntry, v interface{}) error {
+	data, err := entry.Read()
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Error unmarshalling %s: %v", entry.Path, err)
+	}
+
+	return nil
+}
+
+func removeCacheEntries(entries []*CacheEntry, dry bool) error {
+	for _, entry := range entries {
+		if dry {
+			fmt.Printf("Would remove %s\n", entry.Path)
+			continue
+		}
+
+		if err := entry.Remove(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed %s\n", entry.Path)
+	}
+
+	fmt.Printf("%d entries\n", len(entries))
+
+	return nil
+}
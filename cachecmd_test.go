@@ -0,0 +1,82 @@
+package beatles
+
+import (
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func TestListCacheEntriesMissingDirectory(t *testing.T) {
+	useCacheDirectory(t)
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		t.Fatalf("expected an empty cache, got %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no entries, got %d", len(entries))
+	}
+
+	for _, command := range []string{"stats", "ls", "verify", "prune"} {
+		if err := RunCacheCommand(&Profile{}, []string{command}); err != nil {
+			t.Errorf("cache %s: %v", command, err)
+		}
+	}
+}
+
+// Caches an album with a track, as fetching it would have.
+func writeTestAlbum(t *testing.T, albumID, trackID spotify.ID) {
+	t.Helper()
+
+	track := spotify.SimpleTrack{ID: trackID, Name: string(trackID)}
+	album := spotify.FullAlbum{}
+	album.ID = albumID
+	album.Tracks.Tracks = []spotify.SimpleTrack{track}
+
+	writeCacheEntry(t, album, "album-%s.json", albumID)
+	writeCacheEntry(t, []spotify.SimpleTrack{track}, "album-tracks-%s.json", albumID)
+	writeCacheEntry(t, spotify.FullTrack{SimpleTrack: track}, "track-%s.json", trackID)
+}
+
+func TestCachePrune(t *testing.T) {
+	useCacheDirectory(t)
+
+	// Kept's listed by the artist and Excluded is excluded, nothing refers
+	// to Gone any more.
+	writeCacheEntry(t, []spotify.SimpleAlbum{{ID: "al-kept"}}, "artist-albums-%s.json", "ar-testers")
+	writeTestAlbum(t, "al-kept", "tr-kept")
+	writeTestAlbum(t, "al-excluded", "tr-excluded")
+	writeTestAlbum(t, "al-gone", "tr-gone")
+
+	if err := CachePrune(&Profile{ExcludedAlbums: "al-excluded"}, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	entries, err := ListCacheEntries()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	remaining := make(map[string]bool)
+	for _, entry := range entries {
+		remaining[entry.Kind.Name+"-"+entry.ID] = true
+	}
+
+	expected := []string{
+		"artist-albums-ar-testers",
+		"album-al-kept",
+		"album-tracks-al-kept",
+		"track-tr-kept",
+		"album-al-excluded",
+		"album-tracks-al-excluded",
+		"track-tr-excluded",
+	}
+	for _, name := range expected {
+		if !remaining[name] {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("expected only %v to remain, got %v", expected, remaining)
+	}
+}
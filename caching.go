@@ -1,52 +1,229 @@
-package main
+package beatles
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/zmb3/spotify"
 )
 
 const VerboseLogging = false
 
+const DefaultCacheDirectory = ".cache"
+
+// Every cache entry is read and written here, see SetCacheDirectory.
+var CacheDirectory = DefaultCacheDirectory
+
+var QuarantineDirectory = filepath.Join(DefaultCacheDirectory, "quarantine")
+
+func SetCacheDirectory(directory string) {
+	CacheDirectory = directory
+	QuarantineDirectory = filepath.Join(directory, "quarantine")
+}
+
+// Safe for concurrent use, concurrent requests for the same entry share a
+// single fetch.
 type SpotifyCacher struct {
-	spotifyClient *spotify.Client
+	spotifyClient SpotifyClient
+	offline       bool
+	lock          sync.Mutex
+	missing       []string
+	inflight      map[string]*flight
+}
+
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func NewSpotifyCacher(spotifyClient SpotifyClient, offline bool) *SpotifyCacher {
+	return &SpotifyCacher{
+		spotifyClient: spotifyClient,
+		offline:       offline,
+		inflight:      make(map[string]*flight),
+	}
+}
+
+// Runs fn once for key at a time, callers arriving while it's running wait
+// for and share its result.
+func (sc *SpotifyCacher) single(key string, fn func() (interface{}, error)) (interface{}, error) {
+	sc.lock.Lock()
+	if sc.inflight == nil {
+		sc.inflight = make(map[string]*flight)
+	}
+	if f, ok := sc.inflight[key]; ok {
+		sc.lock.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	sc.inflight[key] = f
+	sc.lock.Unlock()
+
+	f.value, f.err = fn()
+
+	sc.lock.Lock()
+	delete(sc.inflight, key)
+	sc.lock.Unlock()
+	close(f.done)
+
+	return f.value, f.err
+}
+
+// When offline, cache misses are recorded instead of being fetched and empty
+// results are returned so the caller can gather every missing key in one run.
+func (sc *SpotifyCacher) miss(cachedFile string) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	key := strings.TrimSuffix(filepath.Base(cachedFile), ".json")
+	for _, k := range sc.missing {
+		if k == key {
+			return
+		}
+	}
+	sc.missing = append(sc.missing, key)
+}
+
+func (sc *SpotifyCacher) Missing() []string {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	return append(make([]string, 0), sc.missing...)
 }
 
 func getFilePath(name string, a ...interface{}) string {
-	return ".cache/" + fmt.Sprintf(name, a...)
+	return filepath.Join(CacheDirectory, fmt.Sprintf(name, a...))
+}
+
+// Cache files wrap the cached JSON along with a checksum of it, so that a
+// truncated or otherwise damaged entry can be told apart from a good one.
+type cacheEnvelope struct {
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Entries written before checksums were introduced are bare JSON, those are
+// returned as is.
+func decodeCacheFile(file []byte) ([]byte, error) {
+	var envelope cacheEnvelope
+	if err := json.Unmarshal(file, &envelope); err != nil || envelope.Checksum == "" {
+		return file, nil
+	}
+
+	if actual := checksum(envelope.Data); actual != envelope.Checksum {
+		return nil, fmt.Errorf("Checksum mismatch (%s != %s)", actual, envelope.Checksum)
+	}
+
+	return envelope.Data, nil
+}
+
+func encodeCacheFile(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(cacheEnvelope{
+		Checksum: checksum(data),
+		Data:     data,
+	})
+}
+
+func quarantine(cachedFile string, reason error) {
+	log.Printf("Quarantining corrupted %s: %v", cachedFile, reason)
+
+	if err := os.MkdirAll(QuarantineDirectory, 0755); err != nil {
+		log.Printf("Error creating %s: %v", QuarantineDirectory, err)
+		os.Remove(cachedFile)
+		return
+	}
+
+	name := fmt.Sprintf("%s.%d", filepath.Base(cachedFile), time.Now().Unix())
+	if err := os.Rename(cachedFile, filepath.Join(QuarantineDirectory, name)); err != nil {
+		log.Printf("Error quarantining %s: %v", cachedFile, err)
+		os.Remove(cachedFile)
+	}
+}
+
+// Returns false if there's no usable entry, in which case the caller should
+// fetch and save. Corrupted entries are quarantined and treated as missing.
+func (sc *SpotifyCacher) load(cachedFile string, v interface{}) (bool, error) {
+	file, err := ioutil.ReadFile(cachedFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error opening %v", err)
+	}
+
+	data, err := decodeCacheFile(file)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		quarantine(cachedFile, err)
+		return false, nil
+	}
+
+	if VerboseLogging {
+		log.Printf("Returning cached %s", cachedFile)
+	}
+
+	return true, nil
+}
+
+func (sc *SpotifyCacher) save(cachedFile string, v interface{}) error {
+	file, err := encodeCacheFile(v)
+	if err != nil {
+		return err
+	}
+
+	// A profile's cache starts out missing.
+	if err := os.MkdirAll(filepath.Dir(cachedFile), 0755); err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(cachedFile, file, 0644)
 }
 
+// The list of playlists is always refreshed when online, it's how changes to
+// individual playlists are noticed.
 func (sc *SpotifyCacher) GetPlaylists(user string) (playlists *PlaylistSet, err error) {
 	cachedFile := getFilePath("playlists-%s.json", user)
-	if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
-		file, err := ioutil.ReadFile(cachedFile)
-		if err != nil {
-			return nil, err
-		}
 
+	if sc.offline {
 		playlists = &PlaylistSet{}
-		err = json.Unmarshal(file, playlists)
-		if err != nil {
-			return nil, err
+		if ok, err := sc.load(cachedFile, playlists); err != nil || ok {
+			return playlists, err
 		}
 
-		if VerboseLogging {
-			log.Printf("Returning cached %v", cachedFile)
-		}
+		sc.miss(cachedFile)
+		return &PlaylistSet{Playlists: make([]Playlist, 0)}, nil
+	}
 
-		return playlists, nil
+	playlists = &PlaylistSet{
+		Playlists: make([]Playlist, 0),
 	}
 
 	limit := 50
 	offset := 0
 	options := spotify.Options{Limit: &limit, Offset: &offset}
-	playlists = &PlaylistSet{
-		Playlists: make([]Playlist, 0),
-	}
 	for {
 		page, err := sc.spotifyClient.GetPlaylistsForUserOpt(user, &options)
 		if err != nil {
@@ -55,9 +232,10 @@ func (sc *SpotifyCacher) GetPlaylists(user string) (playlists *PlaylistSet, err
 
 		for _, iter := range page.Playlists {
 			playlists.Playlists = append(playlists.Playlists, Playlist{
-				ID:   iter.ID,
-				Name: iter.Name,
-				User: user,
+				ID:         iter.ID,
+				Name:       iter.Name,
+				User:       user,
+				SnapshotID: iter.SnapshotID,
 			})
 		}
 
@@ -69,12 +247,7 @@ func (sc *SpotifyCacher) GetPlaylists(user string) (playlists *PlaylistSet, err
 		options.Offset = &offset
 	}
 
-	json, err := json.Marshal(playlists)
-	if err != nil {
-		return nil, fmt.Errorf("Error saving Playlists: %v", err)
-	}
-
-	err = ioutil.WriteFile(cachedFile, json, 0644)
+	err = sc.save(cachedFile, playlists)
 	if err != nil {
 		return nil, fmt.Errorf("Error saving Playlists: %v", err)
 	}
@@ -82,74 +255,115 @@ func (sc *SpotifyCacher) GetPlaylists(user string) (playlists *PlaylistSet, err
 	return
 }
 
-func (sc *SpotifyCacher) Invalidate(id spotify.ID) {
-	if false {
-		log.Printf("Invalidating playlist %v", id)
-	}
+type CachedPlaylistTracks struct {
+	SnapshotID string
+	Tracks     []spotify.PlaylistTrack
+}
 
-	cachedFile := getFilePath("playlist-%s.json", id)
-	os.Remove(cachedFile)
+// Cached tracks are used as long as the playlist's snapshot hasn't changed,
+// offline they're used regardless.
+func (sc *SpotifyCacher) GetPlaylistTracks(playlist Playlist) ([]spotify.PlaylistTrack, error) {
+	v, err := sc.single(getFilePath("playlist-%s.json", playlist.ID), func() (interface{}, error) {
+		return sc.getPlaylistTracks(playlist)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]spotify.PlaylistTrack), nil
 }
 
-func (sc *SpotifyCacher) GetPlaylistTracks(userId string, id spotify.ID) (allTracks []spotify.PlaylistTrack, err error) {
-	cachedFile := getFilePath("playlist-%s.json", id)
-	if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
-		file, err := ioutil.ReadFile(cachedFile)
-		if err != nil {
-			return nil, fmt.Errorf("Error opening %v", err)
-		}
+func (sc *SpotifyCacher) getPlaylistTracks(playlist Playlist) (allTracks []spotify.PlaylistTrack, err error) {
+	cachedFile := getFilePath("playlist-%s.json", playlist.ID)
+	cached := &CachedPlaylistTracks{}
+	ok, err := sc.load(cachedFile, cached)
+	if err != nil {
+		return nil, err
+	}
 
-		allTracks = make([]spotify.PlaylistTrack, 0)
-		err = json.Unmarshal(file, &allTracks)
-		if err != nil {
-			return nil, fmt.Errorf("Error unmarshalling %v", err)
+	if ok {
+		if sc.offline || cached.SnapshotID == playlist.SnapshotID {
+			return cached.Tracks, nil
 		}
 
-		if VerboseLogging {
-			log.Printf("Returning cached %s", cachedFile)
-		}
+		log.Printf("Playlist '%s' changed (%s != %s)", playlist.Name, cached.SnapshotID, playlist.SnapshotID)
+	}
 
-		return allTracks, nil
+	if sc.offline {
+		sc.miss(cachedFile)
+		return make([]spotify.PlaylistTrack, 0), nil
 	}
 
-	allTracks, spotifyErr := GetPlaylistTracks(sc.spotifyClient, id)
+	allTracks, spotifyErr := GetPlaylistTracks(sc.spotifyClient, playlist.ID)
 	if spotifyErr != nil {
 		err = spotifyErr
 		return
 	}
 
-	json, err := json.Marshal(allTracks)
+	err = sc.save(cachedFile, &CachedPlaylistTracks{
+		SnapshotID: playlist.SnapshotID,
+		Tracks:     allTracks,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Error saving playlist tracks: %v", err)
 	}
 
-	err = ioutil.WriteFile(cachedFile, json, 0644)
+	return
+}
+
+func (sc *SpotifyCacher) GetArtist(id spotify.ID) (*spotify.FullArtist, error) {
+	v, err := sc.single(getFilePath("artist-%s.json", id), func() (interface{}, error) {
+		return sc.getArtist(id)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Error saving playlist tracks: %v", err)
+		return nil, err
+	}
+	return v.(*spotify.FullArtist), nil
+}
+
+func (sc *SpotifyCacher) getArtist(id spotify.ID) (artist *spotify.FullArtist, err error) {
+	cachedFile := getFilePath("artist-%s.json", id)
+	if ok, err := sc.load(cachedFile, &artist); err != nil || ok {
+		return artist, err
+	}
+
+	if sc.offline {
+		sc.miss(cachedFile)
+		return &spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: id}}, nil
+	}
+
+	artist, spotifyErr := sc.spotifyClient.GetArtist(id)
+	if spotifyErr != nil {
+		err = spotifyErr
+		return
+	}
+
+	err = sc.save(cachedFile, artist)
+	if err != nil {
+		return nil, fmt.Errorf("Error saving artist: %v", err)
 	}
 
 	return
 }
 
-func (sc *SpotifyCacher) GetAlbum(id spotify.ID) (album *spotify.FullAlbum, err error) {
-	cachedFile := getFilePath("album-%s.json", id)
-	if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
-		file, err := ioutil.ReadFile(cachedFile)
-		if err != nil {
-			return nil, fmt.Errorf("Error opening %v", err)
-		}
-
-		var album *spotify.FullAlbum
-		err = json.Unmarshal(file, &album)
-		if err != nil {
-			return nil, fmt.Errorf("Error unmarshalling %v", err)
-		}
+func (sc *SpotifyCacher) GetAlbum(id spotify.ID) (*spotify.FullAlbum, error) {
+	v, err := sc.single(getFilePath("album-%s.json", id), func() (interface{}, error) {
+		return sc.getAlbum(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*spotify.FullAlbum), nil
+}
 
-		if VerboseLogging {
-			log.Printf("Returning cached %s", cachedFile)
-		}
+func (sc *SpotifyCacher) getAlbum(id spotify.ID) (album *spotify.FullAlbum, err error) {
+	cachedFile := getFilePath("album-%s.json", id)
+	if ok, err := sc.load(cachedFile, &album); err != nil || ok {
+		return album, err
+	}
 
-		return album, nil
+	if sc.offline {
+		sc.miss(cachedFile)
+		return &spotify.FullAlbum{SimpleAlbum: spotify.SimpleAlbum{ID: id}}, nil
 	}
 
 	album, spotifyErr := sc.spotifyClient.GetAlbum(id)
@@ -158,38 +372,34 @@ func (sc *SpotifyCacher) GetAlbum(id spotify.ID) (album *spotify.FullAlbum, err
 		return
 	}
 
-	json, err := json.Marshal(album)
+	err = sc.save(cachedFile, album)
 	if err != nil {
 		return nil, fmt.Errorf("Error saving album tracks: %v", err)
 	}
 
-	err = ioutil.WriteFile(cachedFile, json, 0644)
+	return
+}
+
+func (sc *SpotifyCacher) GetAlbumTracks(id spotify.ID) ([]spotify.SimpleTrack, error) {
+	v, err := sc.single(getFilePath("album-tracks-%s.json", id), func() (interface{}, error) {
+		return sc.getAlbumTracks(id)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Error saving album tracks: %v", err)
+		return nil, err
 	}
-
-	return
+	return v.([]spotify.SimpleTrack), nil
 }
 
-func (sc *SpotifyCacher) GetAlbumTracks(id spotify.ID) (allTracks []spotify.SimpleTrack, err error) {
+func (sc *SpotifyCacher) getAlbumTracks(id spotify.ID) (allTracks []spotify.SimpleTrack, err error) {
 	cachedFile := getFilePath("album-tracks-%s.json", id)
-	if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
-		file, err := ioutil.ReadFile(cachedFile)
-		if err != nil {
-			return nil, fmt.Errorf("Error opening %v", err)
-		}
-
-		allTracks := make([]spotify.SimpleTrack, 0)
-		err = json.Unmarshal(file, &allTracks)
-		if err != nil {
-			return nil, fmt.Errorf("Error unmarshalling %v", err)
-		}
-
-		if VerboseLogging {
-			log.Printf("Returning cached %s", cachedFile)
-		}
+	allTracks = make([]spotify.SimpleTrack, 0)
+	if ok, err := sc.load(cachedFile, &allTracks); err != nil || ok {
+		return allTracks, err
+	}
 
-		return allTracks, nil
+	if sc.offline {
+		sc.miss(cachedFile)
+		return make([]spotify.SimpleTrack, 0), nil
 	}
 
 	allTracks, spotifyErr := GetAlbumTracks(sc.spotifyClient, id)
@@ -198,12 +408,7 @@ func (sc *SpotifyCacher) GetAlbumTracks(id spotify.ID) (allTracks []spotify.Simp
 		return
 	}
 
-	json, err := json.Marshal(allTracks)
-	if err != nil {
-		return nil, fmt.Errorf("Error saving album tracks: %v", err)
-	}
-
-	err = ioutil.WriteFile(cachedFile, json, 0644)
+	err = sc.save(cachedFile, allTracks)
 	if err != nil {
 		return nil, fmt.Errorf("Error saving album tracks: %v", err)
 	}
@@ -211,25 +416,71 @@ func (sc *SpotifyCacher) GetAlbumTracks(id spotify.ID) (allTracks []spotify.Simp
 	return
 }
 
-func (sc *SpotifyCacher) GetArtistAlbums(id spotify.ID) (allAlbums []spotify.SimpleAlbum, err error) {
-	cachedFile := getFilePath("artist-albums-%s.json", id)
-	if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
-		file, err := ioutil.ReadFile(cachedFile)
-		if err != nil {
-			return nil, fmt.Errorf("Error opening %v", err)
+// Fills in the album and album-tracks entries of albums whose tracks aren't
+// cached yet, using the batch album endpoint. Albums with more tracks than
+// come back on their first page are left for GetAlbumTracks.
+func (sc *SpotifyCacher) PrefetchAlbums(ids []spotify.ID) error {
+	if sc.offline {
+		return nil
+	}
+
+	requesting := make([]spotify.ID, 0)
+	for _, id := range ids {
+		if _, err := os.Stat(getFilePath("album-tracks-%s.json", id)); os.IsNotExist(err) {
+			requesting = append(requesting, id)
 		}
+	}
+
+	for i := 0; i < len(requesting); i += 20 {
+		batch := requesting[i:min(i+20, len(requesting))]
 
-		allAlbums := make([]spotify.SimpleAlbum, 0)
-		err = json.Unmarshal(file, &allAlbums)
+		albums, err := sc.spotifyClient.GetAlbums(batch...)
 		if err != nil {
-			return nil, fmt.Errorf("Error unmarshalling %v", err)
+			return err
 		}
 
-		if VerboseLogging {
-			log.Printf("Returning cached %s", cachedFile)
+		for _, album := range albums {
+			if album == nil {
+				continue
+			}
+
+			err = sc.save(getFilePath("album-%s.json", album.ID), album)
+			if err != nil {
+				return fmt.Errorf("Error saving album: %v", err)
+			}
+
+			if len(album.Tracks.Tracks) == album.Tracks.Total {
+				err = sc.save(getFilePath("album-tracks-%s.json", album.ID), album.Tracks.Tracks)
+				if err != nil {
+					return fmt.Errorf("Error saving album tracks: %v", err)
+				}
+			}
 		}
+	}
+
+	return nil
+}
 
-		return allAlbums, nil
+func (sc *SpotifyCacher) GetArtistAlbums(id spotify.ID) ([]spotify.SimpleAlbum, error) {
+	v, err := sc.single(getFilePath("artist-albums-%s.json", id), func() (interface{}, error) {
+		return sc.getArtistAlbums(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]spotify.SimpleAlbum), nil
+}
+
+func (sc *SpotifyCacher) getArtistAlbums(id spotify.ID) (allAlbums []spotify.SimpleAlbum, err error) {
+	cachedFile := getFilePath("artist-albums-%s.json", id)
+	allAlbums = make([]spotify.SimpleAlbum, 0)
+	if ok, err := sc.load(cachedFile, &allAlbums); err != nil || ok {
+		return allAlbums, err
+	}
+
+	if sc.offline {
+		sc.miss(cachedFile)
+		return make([]spotify.SimpleAlbum, 0), nil
 	}
 
 	allAlbums, spotifyErr := GetArtistAlbums(sc.spotifyClient, id)
@@ -238,12 +489,7 @@ func (sc *SpotifyCacher) GetArtistAlbums(id spotify.ID) (allAlbums []spotify.Sim
 		return
 	}
 
-	json, err := json.Marshal(allAlbums)
-	if err != nil {
-		return nil, fmt.Errorf("Error saving artist albums: %v", err)
-	}
-
-	err = ioutil.WriteFile(cachedFile, json, 0644)
+	err = sc.save(cachedFile, allAlbums)
 	if err != nil {
 		return nil, fmt.Errorf("Error saving artist albums: %v", err)
 	}
@@ -252,15 +498,27 @@ func (sc *SpotifyCacher) GetArtistAlbums(id spotify.ID) (allAlbums []spotify.Sim
 }
 
 func (sc *SpotifyCacher) GetTracks(ids []spotify.ID) (tracks []spotify.FullTrack, err error) {
+	cached := make(map[spotify.ID]spotify.FullTrack)
 	requesting := make([]spotify.ID, 0)
 	for _, id := range ids {
-		cachedFile := getFilePath("track-%s.json", id)
-		if _, err := os.Stat(cachedFile); os.IsNotExist(err) {
+		var track spotify.FullTrack
+		ok, err := sc.load(getFilePath("track-%s.json", id), &track)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			cached[id] = track
+		} else {
 			requesting = append(requesting, id)
 		}
 	}
 
-	if len(requesting) > 0 {
+	if sc.offline {
+		for _, id := range requesting {
+			sc.miss(getFilePath("track-%s.json", id))
+		}
+	} else if len(requesting) > 0 {
 		requested, spotifyErr := sc.spotifyClient.GetTracks(requesting...)
 		if spotifyErr != nil {
 			err = spotifyErr
@@ -268,41 +526,26 @@ func (sc *SpotifyCacher) GetTracks(ids []spotify.ID) (tracks []spotify.FullTrack
 		}
 
 		for _, track := range requested {
+			if track == nil {
+				continue
+			}
+
 			cachedFile := getFilePath("track-%s.json", track.ID)
 
-			json, err := json.Marshal(track)
+			err = sc.save(cachedFile, track)
 			if err != nil {
 				return nil, fmt.Errorf("Error saving track: %v", err)
 			}
 
-			err = ioutil.WriteFile(cachedFile, json, 0644)
-			if err != nil {
-				return nil, fmt.Errorf("Error saving track: %v", err)
-			}
+			cached[track.ID] = *track
 		}
 	}
 
 	tracks = make([]spotify.FullTrack, 0)
 
 	for _, id := range ids {
-		cachedFile := getFilePath("track-%s.json", id)
-		if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
-			file, err := ioutil.ReadFile(cachedFile)
-			if err != nil {
-				return nil, fmt.Errorf("Error opening %v", err)
-			}
-
-			var track spotify.FullTrack
-			err = json.Unmarshal(file, &track)
-			if err != nil {
-				return nil, fmt.Errorf("Error unmarshalling %v", err)
-			}
-
+		if track, ok := cached[id]; ok {
 			tracks = append(tracks, track)
-
-			if VerboseLogging {
-				log.Printf("Returning cached %s", cachedFile)
-			}
 		}
 	}
 
@@ -0,0 +1,257 @@
+package beatles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func TestCacherOffline(t *testing.T) {
+	useCacheDirectory(t)
+
+	track := spotify.SimpleTrack{ID: "tr-help", Name: "Help"}
+	writeCacheEntry(t, spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: "ar-testers", Name: "The Testers"}}, "artist-%s.json", "ar-testers")
+	writeCacheEntry(t, []spotify.SimpleTrack{track}, "album-tracks-%s.json", "al-please")
+
+	// Served from the cache without a client.
+	cacher := NewSpotifyCacher(nil, true)
+	artist, err := cacher.GetArtist("ar-testers")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if artist.Name != "The Testers" {
+		t.Fatalf("expected the cached artist, got %v", artist.Name)
+	}
+	tracks, err := cacher.GetAlbumTracks("al-please")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(tracks) != 1 || tracks[0].Name != "Help" {
+		t.Fatalf("expected the cached tracks, got %v", tracks)
+	}
+
+	// Every miss is gathered once, with an empty result so the run goes on.
+	for i := 0; i < 2; i++ {
+		tracks, err = cacher.GetAlbumTracks("al-missing")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(tracks) != 0 {
+			t.Fatalf("expected no tracks, got %v", tracks)
+		}
+	}
+	if _, err := cacher.GetPlaylists("tester"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	missing := cacher.Missing()
+	if len(missing) != 2 || missing[0] != "album-tracks-al-missing" || missing[1] != "playlists-tester" {
+		t.Fatalf("expected the album tracks and playlists to be missed, got %v", missing)
+	}
+}
+
+func TestFetchOffline(t *testing.T) {
+	tc := newTestCatalog()
+	// Fetching expects the cache to be there, it's checked in.
+	if err := os.MkdirAll(useCacheDirectory(t), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	online, err := Fetch(context.Background(), NewSpotifyCacher(tc.fake, false), tc.config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Everything's cached, so nothing's asked of Spotify.
+	offline, err := Fetch(context.Background(), NewSpotifyCacher(nil, true), tc.config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(offline.Albums) != len(online.Albums) || len(offline.ExclusionPlaylists) != len(online.ExclusionPlaylists) {
+		t.Fatalf("expected the cached catalog, got %d albums and %d playlists", len(offline.Albums), len(offline.ExclusionPlaylists))
+	}
+
+	// Every miss is gathered before failing.
+	album := online.Albums[0].Album.ID
+	for _, name := range []string{getFilePath("album-tracks-%s.json", album), getFilePath("playlists-%s.json", tc.config.User)} {
+		if err := os.Remove(name); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	cacher := NewSpotifyCacher(nil, true)
+	_, err = Fetch(context.Background(), cacher, tc.config)
+	if err == nil {
+		t.Fatalf("expected missing entries to fail the fetch")
+	}
+	for _, key := range []string{"album-tracks-" + string(album), "playlists-" + tc.config.User} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s to be listed, got %v", key, err)
+		}
+	}
+	if missing := cacher.Missing(); len(missing) != 2 {
+		t.Errorf("expected two misses, got %v", missing)
+	}
+}
+
+func TestCacheCorruptedEntry(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		corrupt func(file []byte) []byte
+	}{
+		{"truncated", func(file []byte) []byte {
+			return file[:len(file)/2]
+		}},
+		{"checksum mismatch", func(file []byte) []byte {
+			var envelope cacheEnvelope
+			if err := json.Unmarshal(file, &envelope); err != nil {
+				t.Fatalf("%v", err)
+			}
+			envelope.Data = json.RawMessage(strings.Replace(string(envelope.Data), "The Testers", "The Tasters", 1))
+			damaged, err := json.Marshal(envelope)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			return damaged
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Fetching expects the cache to be there, it's checked in.
+			if err := os.MkdirAll(useCacheDirectory(t), 0755); err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			fake := NewFakeSpotify("tester")
+			artist := fake.AddArtist("The Testers")
+			cachedFile := getFilePath("artist-%s.json", artist.ID)
+
+			if _, err := NewSpotifyCacher(fake, false).GetArtist(artist.ID); err != nil {
+				t.Fatalf("%v", err)
+			}
+			file, err := ioutil.ReadFile(cachedFile)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := ioutil.WriteFile(cachedFile, test.corrupt(file), 0644); err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			// Offline it's quarantined and missed rather than used.
+			offline := NewSpotifyCacher(nil, true)
+			if _, err := offline.GetArtist(artist.ID); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if missing := offline.Missing(); len(missing) != 1 || missing[0] != "artist-"+string(artist.ID) {
+				t.Fatalf("expected the corrupted artist to be missed, got %v", missing)
+			}
+			if _, err := os.Stat(cachedFile); !os.IsNotExist(err) {
+				t.Fatalf("expected the corrupted entry to be moved aside, %v", err)
+			}
+			quarantined, err := ioutil.ReadDir(QuarantineDirectory)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if len(quarantined) != 1 {
+				t.Fatalf("expected the entry in quarantine, got %d files", len(quarantined))
+			}
+
+			// Online it's fetched and saved again.
+			fetched, err := NewSpotifyCacher(fake, false).GetArtist(artist.ID)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if fetched.Name != "The Testers" {
+				t.Fatalf("expected the artist fetched again, got %v", fetched.Name)
+			}
+			saved, err := ioutil.ReadFile(cachedFile)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if _, err := decodeCacheFile(saved); err != nil {
+				t.Fatalf("expected a good entry saved, %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeCacheFileUnwrapped(t *testing.T) {
+	// Entries from before checksums are read as they are.
+	bare := []byte(`{"name":"The Testers"}`)
+	data, err := decodeCacheFile(bare)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(data) != string(bare) {
+		t.Fatalf("expected the bare entry back, got %s", data)
+	}
+}
+
+func TestPlaylistSnapshot(t *testing.T) {
+	// Fetching expects the cache to be there, it's checked in.
+	if err := os.MkdirAll(useCacheDirectory(t), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// A playlist whose snapshot changes with its tracks, as Spotify's does.
+	playlist := spotify.SimplePlaylist{ID: "pl-excluded", Name: "The Testers (excluded)", SnapshotID: "snapshot-1"}
+	tracks := []spotify.PlaylistTrack{{Track: spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: "tr-help"}}}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users/tester/playlists", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(spotify.SimplePlaylistPage{Playlists: []spotify.SimplePlaylist{playlist}})
+	})
+	mux.HandleFunc("/v1/playlists/pl-excluded/tracks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(spotify.PlaylistTrackPage{Tracks: tracks})
+	})
+
+	cacher := NewSpotifyCacher(testSpotifyClient(t, mux), false)
+	before, err := cacher.GetPlaylists("tester")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := cacher.GetPlaylistTracks(before.Playlists[0]); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tracks = append(tracks, spotify.PlaylistTrack{Track: spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: "tr-twist"}}})
+	playlist.SnapshotID = "snapshot-2"
+
+	// The same snapshot is served from the cache.
+	cached, err := cacher.GetPlaylistTracks(before.Playlists[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(cached) != 1 {
+		t.Fatalf("expected the cached tracks, got %d", len(cached))
+	}
+
+	// The list's fetched again every run, and a new snapshot fetches the
+	// tracks again.
+	after, err := cacher.GetPlaylists("tester")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if after.Playlists[0].SnapshotID != "snapshot-2" {
+		t.Fatalf("expected the playlists to be fetched again, got %s", after.Playlists[0].SnapshotID)
+	}
+	fetched, err := cacher.GetPlaylistTracks(after.Playlists[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(fetched) != 2 {
+		t.Fatalf("expected the changed playlist's tracks, got %d", len(fetched))
+	}
+
+	// Offline the cached tracks are used whatever the snapshot.
+	offline, err := NewSpotifyCacher(nil, true).GetPlaylistTracks(before.Playlists[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(offline) != 2 {
+		t.Fatalf("expected the cached tracks offline, got %d", len(offline))
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jlewallen/beatles"
+	"github.com/zmb3/spotify"
+)
+
+func runAuth(args []string) error {
+	action := "login"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	var common Common
+	fs := newFlagSet("auth", "[login|status|logout] [flags]", "Logs in to Spotify, saving the token to the profile's token store in the user config directory, or checks an existing token still works. status shows the saved token's expiry and scopes, logout forgets it.")
+	common.AddClientFlags(fs)
+	fs.Parse(args)
+
+	switch action {
+	case "login":
+	case "status":
+		return authStatus(&common)
+	case "logout":
+		return authLogout(&common)
+	default:
+		fs.Usage()
+		return fmt.Errorf("Unknown auth action '%s'", action)
+	}
+
+	logFile, err := openLog(false)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	_, err = common.Client()
+	if err != nil {
+		return err
+	}
+
+	common.Close()
+
+	return nil
+}
+
+func authStatus(common *Common) error {
+	if _, err := common.Profile(); err != nil {
+		return err
+	}
+
+	encrypted, err := beatles.TokensEncrypted()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := beatles.ReadTokens()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Tokens:    %s", beatles.TokensFile())
+	if encrypted {
+		fmt.Printf(" (encrypted)")
+	}
+	fmt.Println()
+
+	saved := tokens.Provider(beatles.SpotifyProvider)
+	if saved == nil {
+		fmt.Println("Spotify:   not logged in, run 'beatles auth login'")
+		return nil
+	}
+
+	token, err := saved.Token()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case token.Expiry.IsZero():
+		fmt.Println("Expires:   never")
+	case token.Expiry.Before(time.Now()):
+		fmt.Printf("Expires:   %s (expired)\n", token.Expiry.Format(time.RFC3339))
+	default:
+		fmt.Printf("Expires:   %s (in %v)\n", token.Expiry.Format(time.RFC3339), time.Until(token.Expiry).Round(time.Second))
+	}
+
+	if token.RefreshToken != "" {
+		fmt.Println("Refresh:   yes, renewed when it expires")
+	} else {
+		fmt.Println("Refresh:   no, log in again when it expires")
+	}
+
+	if saved.Scope != "" {
+		fmt.Printf("Scopes:    %s\n", strings.Join(strings.Fields(saved.Scope), ", "))
+	} else {
+		fmt.Println("Scopes:    unknown, saved before scopes were recorded")
+	}
+
+	return nil
+}
+
+func authLogout(common *Common) error {
+	if _, err := common.Profile(); err != nil {
+		return err
+	}
+
+	removed, err := beatles.RemoveTokens(beatles.SpotifyProvider)
+	if err != nil {
+		return err
+	}
+
+	if !removed {
+		fmt.Println("Not logged in.")
+		return nil
+	}
+
+	fmt.Printf("Removed the Spotify token from %s.\n", beatles.TokensFile())
+	fmt.Println("Spotify can't revoke tokens, the access token works until it expires. Remove the app under Apps on your Spotify account page to cut off the refresh token too.")
+
+	return nil
+}
+
+func runFetch(args []string) error {
+	var common Common
+	fs := newFlagSet("fetch", "[flags]", "Fetches the artist's albums and tracks, the excluded albums and the exclusion playlists into .cache.")
+	common.AddFlags(fs, false)
+	fs.Parse(args)
+
+	logFile, err := openLog(false)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	client, err := common.Client()
+	if err != nil {
+		return err
+	}
+
+	catalog, err := beatles.Fetch(common.Context(), beatles.NewSpotifyCacher(client, common.Offline), common.Config())
+	if err != nil {
+		return err
+	}
+
+	common.Close()
+
+	log.Printf("Fetched %d albums, %d excluded albums and %d exclusion playlists", len(catalog.Albums), len(catalog.ExcludedAlbums), len(catalog.ExclusionPlaylists))
+
+	return finish(logFile, catalog.Summary)
+}
+
+func runAnalyze(args []string) error {
+	var common Common
+	fs := newFlagSet("analyze", "[flags]", "Groups recordings into songs and applies exclusions, printing a summary. Reads from .cache unless --offline=false.")
+	common.AddFlags(fs, true)
+	fs.Parse(args)
+
+	logFile, err := openLog(true)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	analysis, _, err := common.Analyze()
+	if err != nil {
+		return err
+	}
+
+	common.Close()
+
+	excluded := 0
+	originals := 0
+	reasons := make(map[string]int)
+	for _, track := range analysis.Tracks {
+		if track.Excluded {
+			excluded += 1
+		}
+		if track.Original {
+			originals += 1
+		}
+		for _, reason := range track.ExcludedReasons {
+			reasons[beatles.ExclusionRule(reason)] += 1
+		}
+	}
+
+	fmt.Printf("%d tracks, %d songs, %d originals, %d excluded\n", len(analysis.Tracks), len(analysis.Songs), originals, excluded)
+	fmt.Printf("\nExclusions:\n")
+	for _, reason := range sortedKeys(reasons) {
+		fmt.Printf("  %-40s %d\n", reason, reasons[reason])
+	}
+	fmt.Printf("\nPlaylists:\n")
+	for _, plan := range beatles.PlanPlaylists(analysis, beatles.SyncOptions{RebuildBase: true}) {
+		fmt.Printf("  %-40s %d\n", plan.Name, len(plan.Tracks))
+	}
+
+	return finish(logFile, analysis.Catalog.Summary)
+}
+
+func runReport(args []string) error {
+	var common Common
+	var output string
+	var options beatles.ReportOptions
+	fs := newFlagSet("report", "[flags]", "Renders the org reports and audit log, and with --html the same as static pages. Reads from .cache unless --offline=false, so no login is needed.")
+	common.AddFlags(fs, true)
+	fs.StringVar(&output, "output", "data", "directory to write reports to")
+	fs.BoolVar(&options.Html, "html", false, "also write sortable, filterable HTML pages with their stylesheet and script")
+	fs.Parse(args)
+
+	logFile, err := openLog(false)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	analysis, _, err := common.Analyze()
+	if err != nil {
+		return err
+	}
+
+	common.Close()
+
+	err = beatles.Report(analysis, output, options)
+	if err != nil {
+		return err
+	}
+
+	return finish(logFile, analysis.Catalog.Summary)
+}
+
+func runSync(args []string) error {
+	var common Common
+	var options beatles.SyncOptions
+	var resume bool
+	fs := newFlagSet("sync", "[flags]", "Sets the tracks of the generated playlists, creating any that are missing. Reports aren't rendered.\nProgress is journaled, to "+beatles.SyncJournalFile+" without a profile, so an interrupted sync can be resumed.")
+	common.AddFlags(fs, false)
+	fs.BoolVar(&options.ReadOnly, "dry", false, "only log the playlists that would be set")
+	fs.BoolVar(&options.RebuildBase, "rebuild-base", false, "also set the (all) and (short) playlists")
+	fs.BoolVar(&resume, "resume", false, "continue an interrupted sync where it stopped")
+	fs.Parse(args)
+
+	logFile, err := openLog(false)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	if common.Offline && !options.ReadOnly {
+		return fmt.Errorf("Can't sync offline, use --dry")
+	}
+
+	if common.AppOnly && !options.ReadOnly {
+		return fmt.Errorf("Can't sync as the app only, playlists need a login")
+	}
+
+	if !options.ReadOnly {
+		common.writes = true
+
+		profile, err := common.Profile()
+		if err != nil {
+			return err
+		}
+
+		journal, err := beatles.LoadSyncJournal(profile.Journal)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case resume && journal == nil:
+			return fmt.Errorf("Nothing to resume, no %s", profile.Journal)
+		case resume:
+			log.Printf("Resuming the sync started %v", journal.Started.Format(time.RFC3339))
+		case journal != nil:
+			log.Printf("Starting over, discarding the sync started %v", journal.Started.Format(time.RFC3339))
+			journal = nil
+		}
+
+		if journal == nil {
+			journal = beatles.NewSyncJournal(profile.Journal)
+		}
+
+		options.Journal = journal
+	}
+
+	analysis, client, err := common.Analyze()
+	if err != nil {
+		return err
+	}
+
+	err = beatles.Sync(common.Context(), client, analysis, options)
+	if err != nil {
+		finish(logFile, analysis.Catalog.Summary)
+		return err
+	}
+
+	common.Close()
+
+	return finish(logFile, analysis.Catalog.Summary)
+}
+
+type QueryFilter struct {
+	Excluded      bool
+	Included      bool
+	Originals     bool
+	MinRecordings int
+}
+
+func (qf *QueryFilter) Matches(track *beatles.TrackInfo, pattern string) bool {
+	if pattern != "" && !strings.Contains(strings.ToLower(track.Name), strings.ToLower(pattern)) && string(track.ID) != pattern {
+		return false
+	}
+	if qf.Excluded && !track.Excluded {
+		return false
+	}
+	if qf.Included && track.Excluded {
+		return false
+	}
+	if qf.Originals && !track.Original {
+		return false
+	}
+	return track.Recordings >= qf.MinRecordings
+}
+
+func runQuery(args []string) error {
+	var common Common
+	var filter QueryFilter
+	fs := newFlagSet("query", "[flags] [name]", "Lists tracks whose name contains name, or with that ID, after analysis.")
+	common.AddFlags(fs, true)
+	fs.BoolVar(&filter.Excluded, "excluded", false, "only excluded tracks")
+	fs.BoolVar(&filter.Included, "included", false, "only tracks that weren't excluded")
+	fs.BoolVar(&filter.Originals, "originals", false, "only original recordings")
+	fs.IntVar(&filter.MinRecordings, "min-recordings", 0, "only songs recorded at least this many times")
+	fs.Parse(args)
+
+	logFile, err := openLog(true)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	analysis, _, err := common.Analyze()
+	if err != nil {
+		return err
+	}
+
+	common.Close()
+
+	pattern := strings.Join(fs.Args(), " ")
+	matched := 0
+	for _, track := range analysis.Tracks {
+		if !filter.Matches(track, pattern) {
+			continue
+		}
+
+		fmt.Printf("%s | %s | %s | %d | %s\n", track.ID, track.Name, track.Album, track.Recordings, track.ExcludedReason())
+		matched += 1
+	}
+
+	fmt.Printf("%d tracks\n", matched)
+
+	return finish(logFile, analysis.Catalog.Summary)
+}
+
+func runExplain(args []string) error {
+	var common Common
+	fs := newFlagSet("explain", "[flags] <name or id>", "Shows the recordings of a song, which is the original, why each was excluded and which playlists each is on.")
+	common.AddFlags(fs, true)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Nothing to explain")
+	}
+
+	logFile, err := openLog(true)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	analysis, _, err := common.Analyze()
+	if err != nil {
+		return err
+	}
+
+	common.Close()
+
+	query := strings.Join(fs.Args(), " ")
+
+	playlists := make(map[spotify.ID][]string)
+	for _, plan := range beatles.PlanPlaylists(analysis, beatles.SyncOptions{RebuildBase: true}) {
+		for _, id := range plan.Tracks {
+			playlists[id] = append(playlists[id], plan.Name)
+		}
+	}
+
+	explained := 0
+	for _, song := range analysis.Songs {
+		if !songMatches(song, query) {
+			continue
+		}
+
+		fmt.Printf("%s, released %s, %d recordings\n", song.ShortName, song.ReleaseDate.Format("2006-01-02"), len(song.Tracks))
+
+		for _, track := range song.Tracks {
+			fmt.Printf("\n  %s (%s)\n", track.Name, track.ID)
+			fmt.Printf("    Album: %s (%s)\n", track.Album, track.AlbumReleaseDate.Format("2006-01-02"))
+			fmt.Printf("    Duration: %ds, popularity %d\n", track.Duration/1000, track.Popularity)
+			if track.Original {
+				fmt.Printf("    Original\n")
+			}
+			if track.Excluded {
+				fmt.Printf("    Excluded: %s\n", track.ExcludedReason())
+			}
+			if names, ok := playlists[track.ID]; ok {
+				fmt.Printf("    Playlists: %s\n", strings.Join(names, ", "))
+			} else {
+				fmt.Printf("    Playlists: none\n")
+			}
+		}
+
+		fmt.Println()
+		explained += 1
+	}
+
+	if explained == 0 {
+		return fmt.Errorf("No songs matching '%s'", query)
+	}
+
+	return finish(logFile, analysis.Catalog.Summary)
+}
+
+func songMatches(song *beatles.Song, query string) bool {
+	if strings.EqualFold(song.ShortName, query) {
+		return true
+	}
+
+	for _, track := range song.Tracks {
+		if string(track.ID) == query || strings.EqualFold(track.Name, query) {
+			return true
+		}
+	}
+
+	return false
+}
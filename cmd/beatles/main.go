@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/jlewallen/beatles"
+	"github.com/zmb3/spotify"
+)
+
+type Command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+var commands = []Command{
+	{"auth", "log in to Spotify, or show or forget the saved token", runAuth},
+	{"fetch", "fetch the discography and playlists into the cache", runFetch},
+	{"analyze", "group and exclude tracks, printing a summary", runAnalyze},
+	{"report", "render the org reports into data/", runReport},
+	{"sync", "set the generated playlists on Spotify", runSync},
+	{"query", "list tracks matching a name and filters", runQuery},
+	{"explain", "show why a track is or isn't on each playlist", runExplain},
+	{"cache", "inspect and manage the cache", runCache},
+	{"generate", "write a synthetic discography in the cache layout", beatles.RunGenerate},
+	{"fake-server", "serve a cache directory as a fake Spotify", beatles.RunFakeServer},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: beatles <command> [flags]\n\nCommands:\n")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", command.Name, command.Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'beatles <command> --help' for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "--help" || os.Args[1] == "-h" {
+		usage()
+		return
+	}
+
+	for _, command := range commands {
+		if command.Name == os.Args[1] {
+			if err := command.Run(os.Args[2:]); err != nil {
+				log.SetOutput(os.Stderr)
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func newFlagSet(name, usage, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: beatles %s %s\n\n%s\n\n", name, usage, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Flags shared by every command that runs some of the pipeline.
+type Common struct {
+	ProfileName    string
+	User           string
+	ArtistName     string
+	ArtistId       string
+	ExcludedAlbums string
+	Offline        bool
+	AppOnly        bool
+	Workers        int
+	RecordFixtures string
+	ReplayFixtures string
+	SpotifyUrl     string
+	RequestsPerSec float64
+	RequestTimeout time.Duration
+	Credentials    string
+	CallbackAddr   string
+	RedirectUrl    string
+	NoBrowser      bool
+	TokenKeyFile   string
+	// Only a sync that isn't dry gets a client that can change the account.
+	writes   bool
+	flags    *flag.FlagSet
+	profile  *beatles.Profile
+	fixtures *beatles.FixtureTransport
+	run      context.Context
+	requests context.Context
+}
+
+func (c *Common) AddFlags(fs *flag.FlagSet, offline bool) {
+	fs.StringVar(&c.User, "user", "jlewalle", "user owning the playlists, defaults to the profile's")
+	fs.StringVar(&c.ArtistName, "artist-name", "the beatles", "artist name, prefixes the playlists that are read and written")
+	fs.StringVar(&c.ArtistId, "artist-id", "3WrFJ7ztbogyGnTHbHJFl2", "artist")
+	fs.StringVar(&c.ExcludedAlbums, "excluded-albums", "", "comma separated albums whose tracks are excluded, defaults to the profile's")
+	fs.BoolVar(&c.Offline, "offline", offline, "serve everything from .cache, never touch the network")
+	fs.BoolVar(&c.AppOnly, "app-only", false, "authorize as the app alone, no login needed, exclusion playlists are read from .cache")
+	fs.IntVar(&c.Workers, "workers", beatles.DefaultWorkers, "most requests made to Spotify at once")
+	c.AddClientFlags(fs)
+}
+
+func (c *Common) AddClientFlags(fs *flag.FlagSet) {
+	c.flags = fs
+	fs.StringVar(&c.ProfileName, "profile", os.Getenv("BEATLES_PROFILE"), "profile from "+beatles.DefaultProfilesFile+", with its own tokens, cache and user")
+	fs.StringVar(&c.RecordFixtures, "record-fixtures", "", "record Spotify requests and responses into this directory")
+	fs.StringVar(&c.ReplayFixtures, "replay-fixtures", "", "serve Spotify requests from fixtures in this directory, failing on anything unexpected")
+	fs.StringVar(&c.SpotifyUrl, "spotify-url", "", "talk to this server instead of Spotify, a fake-server for example")
+	fs.Float64Var(&c.RequestsPerSec, "requests-per-second", beatles.DefaultRequestsPerSecond, "most requests made to Spotify per second, 0 for no limit")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", beatles.DefaultRequestTimeout, "longest to wait for each attempt at a Spotify request, 0 for no limit")
+	fs.StringVar(&c.Credentials, "credentials", "", "file with the Spotify app's credentials, defaults to $BEATLES_CREDENTIALS or "+beatles.DefaultCredentialsFile)
+	fs.StringVar(&c.CallbackAddr, "callback-address", "", "address to listen on for the login callback, overrides the credentials file")
+	fs.StringVar(&c.RedirectUrl, "redirect-url", "", "where Spotify sends the browser after logging in, overrides the credentials file")
+	fs.BoolVar(&c.NoBrowser, "no-browser", false, "when logging in, also accept the address the browser was sent back to pasted into the terminal")
+	fs.StringVar(&c.TokenKeyFile, "token-key-file", "", "encrypt the token store with the key in this file, defaults to $BEATLES_TOKEN_KEY_FILE, or give $BEATLES_TOKEN_PASSPHRASE")
+}
+
+// The first interrupt cancels the run, stages finish what's in flight, the
+// playlist being written say, and start nothing more. The second abandons
+// requests in flight and the third exits as usual.
+func (c *Common) Context() context.Context {
+	if c.run != nil {
+		return c.run
+	}
+
+	run, cancelRun := context.WithCancel(context.Background())
+	requests, cancelRequests := context.WithCancel(context.Background())
+	c.run, c.requests = run, requests
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Interrupted, finishing what's in flight, interrupt again to stop now")
+		cancelRun()
+		<-signals
+		log.Printf("Interrupted, abandoning requests in flight")
+		cancelRequests()
+		signal.Stop(signals)
+	}()
+
+	return c.run
+}
+
+// Loads and applies the profile the first time it's asked for.
+func (c *Common) Profile() (*beatles.Profile, error) {
+	if c.profile != nil {
+		return c.profile, nil
+	}
+
+	profile, err := beatles.LoadProfile("", c.ProfileName)
+	if err != nil {
+		return nil, err
+	}
+
+	tc, err := beatles.NewTokenCipher(c.TokenKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.Apply()
+	beatles.SetTokenCipher(tc)
+	c.profile = profile
+
+	return profile, nil
+}
+
+// An explicit --user wins over the profile's.
+func (c *Common) user() string {
+	explicit := false
+	if c.flags != nil {
+		c.flags.Visit(func(f *flag.Flag) {
+			if f.Name == "user" {
+				explicit = true
+			}
+		})
+	}
+
+	if !explicit && c.profile != nil && c.profile.User != "" {
+		return c.profile.User
+	}
+
+	return c.User
+}
+
+// The profile's, so that prune keeps what sync excludes, unless there's an
+// explicit --excluded-albums.
+func (c *Common) excludedAlbums() string {
+	if c.ExcludedAlbums == "" && c.profile != nil {
+		return c.profile.ExcludedAlbums
+	}
+	if c.ExcludedAlbums == "" {
+		return beatles.DefaultExcludedAlbums
+	}
+
+	return c.ExcludedAlbums
+}
+
+func (c *Common) Config() *beatles.Config {
+	config := &beatles.Config{
+		User:           c.user(),
+		ArtistID:       spotify.ID(c.ArtistId),
+		ArtistName:     c.ArtistName,
+		ExcludedAlbums: beatles.ParseAlbumIDs(c.excludedAlbums()),
+		Workers:        c.Workers,
+		CatalogOnly:    c.AppOnly,
+	}
+
+	return config
+}
+
+// Returns nil when offline, the cacher never asks for a client then.
+func (c *Common) Client() (beatles.SpotifyClient, error) {
+	client, err := c.client()
+	if err != nil || client == nil || c.writes {
+		return client, err
+	}
+
+	return beatles.NewReadOnlyClient(client), nil
+}
+
+func (c *Common) client() (beatles.SpotifyClient, error) {
+	if _, err := c.Profile(); err != nil {
+		return nil, err
+	}
+
+	if c.Offline {
+		log.Printf("Offline, serving from %s", beatles.CacheDirectory)
+		return nil, nil
+	}
+
+	if c.ReplayFixtures != "" {
+		c.Context()
+		client, fixtures, err := beatles.NewReplayClient(c.requests, c.ReplayFixtures)
+		if err != nil {
+			return nil, fmt.Errorf("Error replaying fixtures: %v", err)
+		}
+		c.fixtures = fixtures
+		return client, nil
+	}
+
+	beatles.SetRequestsPerSecond(c.RequestsPerSec)
+	beatles.SetRequestTimeout(c.RequestTimeout)
+
+	if c.SpotifyUrl != "" {
+		if err := beatles.SetSpotifyUrl(c.SpotifyUrl); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.RecordFixtures != "" {
+		if _, err := beatles.RecordFixtures(c.RecordFixtures); err != nil {
+			return nil, fmt.Errorf("Error recording fixtures: %v", err)
+		}
+	}
+
+	settings, err := beatles.LoadAuthSettings(c.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	if c.CallbackAddr != "" {
+		settings.CallbackAddress = c.CallbackAddr
+	}
+	if c.RedirectUrl != "" {
+		settings.SpotifyRedirectUrl = c.RedirectUrl
+	}
+	beatles.SetAuthSettings(settings)
+
+	c.Context()
+
+	if c.AppOnly {
+		client, err := beatles.NewAppClient(c.requests)
+		if err != nil {
+			return nil, fmt.Errorf("Error authenticating: %v", err)
+		}
+		return client, nil
+	}
+
+	var options beatles.LoginOptions
+	if c.NoBrowser {
+		options.Pasted = os.Stdin
+	}
+
+	client, err := beatles.AuthenticateSpotify(c.requests, options)
+	if err != nil {
+		return nil, fmt.Errorf("Error authenticating: %v", err)
+	}
+
+	return client, nil
+}
+
+func (c *Common) Close() {
+	if c.fixtures == nil {
+		return
+	}
+
+	unused, err := c.fixtures.Unused()
+	if err != nil {
+		log.Printf("Error checking fixtures: %v", err)
+		return
+	}
+
+	for _, name := range unused {
+		log.Printf("Unused fixture: %s", name)
+	}
+}
+
+// Runs Fetch through Exclude.
+func (c *Common) Analyze() (*beatles.Analysis, beatles.SpotifyClient, error) {
+	client, err := c.Client()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	catalog, err := beatles.Fetch(c.Context(), beatles.NewSpotifyCacher(client, c.Offline), c.Config())
+	if err != nil {
+		if c.Offline {
+			return nil, nil, fmt.Errorf("%v\nRun 'beatles fetch' first", err)
+		}
+		return nil, nil, err
+	}
+
+	return beatles.Exclude(beatles.Group(beatles.Build(catalog))), client, nil
+}
+
+func runCache(args []string) error {
+	var profileName string
+	fs := newFlagSet("cache", "[--profile name] <stats|ls|show|invalidate|verify|prune|export|import> [options]", "Inspects and manages the cache, the profile's own when it has one.")
+	fs.StringVar(&profileName, "profile", os.Getenv("BEATLES_PROFILE"), "profile from "+beatles.DefaultProfilesFile+", with its own tokens, cache and user")
+	fs.Parse(args)
+
+	profile, err := beatles.LoadProfile("", profileName)
+	if err != nil {
+		return err
+	}
+
+	profile.Apply()
+
+	return beatles.RunCacheCommand(profile, fs.Args())
+}
+
+// Pipeline logging goes to beatles.log and, unless quiet, to stdout.
+func openLog(quiet bool) (*os.File, error) {
+	logFile, err := os.OpenFile("beatles.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening file: %v", err)
+	}
+
+	if quiet {
+		log.SetOutput(logFile)
+	} else {
+		log.SetOutput(io.MultiWriter(logFile, os.Stdout))
+	}
+
+	return logFile, nil
+}
+
+// Logs what succeeded and failed, failures are always shown on stderr even
+// when logging quietly.
+func finish(logFile *os.File, summary *beatles.Summary) error {
+	if summary.Err() != nil {
+		log.SetOutput(io.MultiWriter(logFile, os.Stderr))
+	}
+
+	summary.Log()
+
+	return summary.Err()
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0)
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
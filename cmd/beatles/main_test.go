@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jlewallen/beatles"
+)
+
+func TestClientReadOnly(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("BEATLES_PROFILES", filepath.Join(t.TempDir(), beatles.DefaultProfilesFile))
+	t.Setenv("BEATLES_TOKEN_KEY_FILE", "")
+	t.Setenv("BEATLES_TOKEN_PASSPHRASE", "")
+
+	// Only a sync that's writing gets a client that can.
+	for _, writes := range []bool{false, true} {
+		common := Common{ReplayFixtures: t.TempDir(), writes: writes}
+		client, err := common.Client()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, readOnly := client.(*beatles.ReadOnlyClient); readOnly == writes {
+			t.Errorf("expected a read only client to be %v when writing is %v, got %T", !writes, writes, client)
+		}
+	}
+}
+
+func TestConfigExcludedAlbums(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("BEATLES_TOKEN_KEY_FILE", "")
+	t.Setenv("BEATLES_TOKEN_PASSPHRASE", "")
+
+	profiles := filepath.Join(t.TempDir(), beatles.DefaultProfilesFile)
+	t.Setenv("BEATLES_PROFILES", profiles)
+	data := `{"testers":{"cache":"` + filepath.Join(t.TempDir(), "cache") + `","excluded_albums":"al-1, al-2"}}`
+	if err := ioutil.WriteFile(profiles, []byte(data), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The profile's, which prune keeps too, unless they're given.
+	for _, test := range []struct {
+		profile  string
+		excluded string
+		expected string
+	}{
+		{"testers", "", "al-1,al-2"},
+		{"testers", "al-3", "al-3"},
+		{"", "", beatles.DefaultExcludedAlbums},
+	} {
+		common := Common{ProfileName: test.profile, ExcludedAlbums: test.excluded}
+		if _, err := common.Profile(); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		ids := make([]string, 0)
+		for _, id := range common.Config().ExcludedAlbums {
+			ids = append(ids, string(id))
+		}
+		if excluded := strings.Join(ids, ","); excluded != test.expected {
+			t.Errorf("expected %s excluded for '%s', got %s", test.expected, test.profile, excluded)
+		}
+	}
+}
+
+// Runs main with BEATLES_TEST_ARGS, one to a line, instead of the tests, so
+// that a test can see how the command exits.
+func TestMain(m *testing.M) {
+	if args := os.Getenv("BEATLES_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"beatles"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func runBeatles(t *testing.T, directory string, args ...string) (int, string) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = directory
+	cmd.Env = append(os.Environ(), "BEATLES_TEST_ARGS="+strings.Join(args, "\n"), "XDG_CONFIG_HOME="+t.TempDir(), "BEATLES_PROFILES=", "BEATLES_PROFILE=")
+	output, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), string(output)
+	}
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return 0, string(output)
+}
+
+func TestCommands(t *testing.T) {
+	directory := t.TempDir()
+
+	var options beatles.GeneratorOptions
+	options.AddFlags(flag.NewFlagSet("generate", flag.ContinueOnError))
+	artist, excluded := beatles.GenerateDiscography(options, beatles.NewFakeSpotify(options.User))
+	excludedIds := make([]string, 0)
+	for _, id := range excluded {
+		excludedIds = append(excludedIds, string(id))
+	}
+	catalog := []string{"--artist-id", string(artist.ID), "--artist-name", options.ArtistName, "--excluded-albums", strings.Join(excludedIds, ","), "--user", options.User}
+
+	for _, test := range []struct {
+		args     []string
+		exit     int
+		expected string
+	}{
+		{[]string{"help"}, 0, "Commands:"},
+		{[]string{"nonsense"}, 2, "Usage: beatles <command>"},
+		{[]string{"generate", "--output", beatles.DefaultCacheDirectory}, 0, "Generated"},
+		{append([]string{"analyze"}, catalog...), 0, "Playlists:"},
+		{[]string{"analyze", "--excluded-albums", "al-missing"}, 1, "Offline and missing"},
+	} {
+		exit, output := runBeatles(t, directory, test.args...)
+		if exit != test.exit || !strings.Contains(output, test.expected) {
+			t.Errorf("beatles %v: expected %d and '%s', got %d\n%s", test.args, test.exit, test.expected, exit, output)
+		}
+	}
+}
+
+func TestFinish(t *testing.T) {
+	logFile, err := os.Create(filepath.Join(t.TempDir(), "beatles.log"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer logFile.Close()
+	defer log.SetOutput(os.Stderr)
+
+	// Any failed unit fails the command, and so its exit code.
+	summary := beatles.NewSummary()
+	summary.Succeed("fetch", "Please")
+	if err := finish(logFile, summary); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	summary.Fail("fetch", "Again", errors.New("not found"))
+	if err := finish(logFile, summary); err == nil || err.Error() != "1 of 2 failed" {
+		t.Fatalf("expected 1 of 2 failed, got %v", err)
+	}
+
+	logged, err := ioutil.ReadFile(logFile.Name())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(string(logged), "FAILED fetch: Again: not found") {
+		t.Fatalf("expected the failure logged, got %s", logged)
+	}
+}
@@ -0,0 +1,599 @@
+package beatles
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// Builds a fake from a directory laid out like .cache, so a cache (or an
+// imported bundle) doubles as the catalog.
+func LoadFakeSpotify(directory, userID string) (*FakeSpotify, error) {
+	fake := NewFakeSpotify(userID)
+
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	// Artists and albums first so album listings and tracks have something to
+	// attach to.
+	for pass := 0; pass < 3; pass++ {
+		for _, info := range infos {
+			kind, id := ParseCacheFileName(info.Name())
+			if kind == nil {
+				continue
+			}
+
+			if fakeLoadPass(kind.Name) != pass {
+				continue
+			}
+
+			file, err := ioutil.ReadFile(filepath.Join(directory, info.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := decodeCacheFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("Error loading %s: %v", info.Name(), err)
+			}
+
+			if err := loadFakeEntry(fake, kind.Name, id, data); err != nil {
+				return nil, fmt.Errorf("Error loading %s: %v", info.Name(), err)
+			}
+		}
+	}
+
+	return fake, nil
+}
+
+func fakeLoadPass(kind string) int {
+	switch kind {
+	case "artist", "album":
+		return 0
+	case "artist-albums", "playlists":
+		return 1
+	}
+	return 2
+}
+
+func loadFakeEntry(fake *FakeSpotify, kind, id string, data []byte) error {
+	switch kind {
+	case "artist":
+		artist := &spotify.FullArtist{}
+		if err := json.Unmarshal(data, artist); err != nil {
+			return err
+		}
+		fake.PutArtist(artist)
+	case "album":
+		album := &spotify.FullAlbum{}
+		if err := json.Unmarshal(data, album); err != nil {
+			return err
+		}
+		fake.PutAlbum("", album)
+	case "artist-albums":
+		albums := make([]spotify.SimpleAlbum, 0)
+		if err := json.Unmarshal(data, &albums); err != nil {
+			return err
+		}
+		if _, ok := fake.Artists[spotify.ID(id)]; !ok {
+			fake.PutArtist(&spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: spotify.ID(id)}})
+		}
+		for _, album := range albums {
+			full := &spotify.FullAlbum{SimpleAlbum: album}
+			if existing, ok := fake.Albums[album.ID]; ok {
+				full = existing
+			}
+			fake.PutAlbum(spotify.ID(id), full)
+		}
+	case "playlists":
+		playlists := &PlaylistSet{}
+		if err := json.Unmarshal(data, playlists); err != nil {
+			return err
+		}
+		for _, playlist := range playlists.Playlists {
+			if existing := fakePlaylistByID(fake, playlist.ID); existing != nil {
+				existing.Name = playlist.Name
+				existing.Owner.ID = playlist.User
+				continue
+			}
+			fake.PutPlaylist(&FakePlaylist{
+				SimplePlaylist: spotify.SimplePlaylist{
+					ID:         playlist.ID,
+					Name:       playlist.Name,
+					SnapshotID: playlist.SnapshotID,
+					Owner:      spotify.User{ID: playlist.User},
+				},
+				TrackIDs: make([]spotify.ID, 0),
+			})
+		}
+	case "album-tracks":
+		tracks := make([]spotify.SimpleTrack, 0)
+		if err := json.Unmarshal(data, &tracks); err != nil {
+			return err
+		}
+		fake.PutAlbumTracks(spotify.ID(id), tracks)
+	case "track":
+		track := &spotify.FullTrack{}
+		if err := json.Unmarshal(data, track); err != nil {
+			return err
+		}
+		fake.PutTrack(track)
+	case "playlist":
+		cached := &CachedPlaylistTracks{}
+		if err := json.Unmarshal(data, cached); err != nil {
+			return err
+		}
+		playlist := fakePlaylistByID(fake, spotify.ID(id))
+		if playlist == nil {
+			playlist = &FakePlaylist{
+				SimplePlaylist: spotify.SimplePlaylist{
+					ID:         spotify.ID(id),
+					SnapshotID: cached.SnapshotID,
+					Owner:      fake.User.User,
+				},
+			}
+			fake.PutPlaylist(playlist)
+		}
+		playlist.TrackIDs = make([]spotify.ID, 0)
+		for _, track := range cached.Tracks {
+			playlist.TrackIDs = append(playlist.TrackIDs, track.Track.ID)
+			if _, ok := fake.Tracks[track.Track.ID]; !ok {
+				full := track.Track
+				fake.PutTrack(&full)
+			}
+		}
+		playlist.SimplePlaylist.Tracks.Total = uint(len(playlist.TrackIDs))
+	}
+
+	return nil
+}
+
+func fakePlaylistByID(fake *FakeSpotify, id spotify.ID) *FakePlaylist {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	return fake.playlist(id)
+}
+
+const FakeAccessToken = "fake-access-token"
+
+// Issued by the client credentials flow, it's refused anything belonging to
+// a user.
+const FakeAppToken = "fake-app-token"
+
+// Serves the parts of the Web API and accounts service the CLI uses, on top
+// of a FakeSpotify. Authorization always succeeds and any bearer token is
+// accepted.
+// ThrottleEvery and FailEvery make every Nth API request fail with a 429 or a
+// 503, to exercise retries.
+type FakeSpotifyServer struct {
+	Fake          *FakeSpotify
+	ThrottleEvery int
+	FailEvery     int
+	// How long issued access tokens last, an hour when zero.
+	TokenLifetime time.Duration
+	// Refreshing fails with invalid_grant, as it does once a user revokes
+	// access.
+	RevokeRefreshTokens bool
+	lock                sync.Mutex
+	requests            int
+	refreshes           int
+	codes               map[string]fakeCode
+	issued              int
+}
+
+// An authorization code, with what it was authorized for.
+type fakeCode struct {
+	challenge string
+	scope     string
+}
+
+func (fss *FakeSpotifyServer) misbehave(w http.ResponseWriter) bool {
+	fss.lock.Lock()
+	fss.requests += 1
+	number := fss.requests
+	fss.lock.Unlock()
+
+	if fss.ThrottleEvery > 0 && number%fss.ThrottleEvery == 0 {
+		log.Printf("fake-server: throttling")
+		w.Header().Set("Retry-After", "1")
+		writeFakeError(w, fakeError(http.StatusTooManyRequests, "API rate limit exceeded"))
+		return true
+	}
+
+	if fss.FailEvery > 0 && number%fss.FailEvery == 0 {
+		log.Printf("fake-server: failing")
+		writeFakeError(w, fakeError(http.StatusServiceUnavailable, "Service unavailable"))
+		return true
+	}
+
+	return false
+}
+
+func (fss *FakeSpotifyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("fake-server: %s %s", r.Method, r.URL)
+
+	if r.URL.Path == "/authorize" {
+		fss.authorize(w, r)
+		return
+	}
+
+	if r.URL.Path == "/api/token" {
+		fss.token(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeFakeError(w, fakeError(http.StatusUnauthorized, "No token provided"))
+		return
+	}
+
+	if r.Header.Get("Authorization") == "Bearer "+FakeAppToken && fakeNeedsUser(r) {
+		writeFakeError(w, fakeError(http.StatusUnauthorized, "This request requires user authentication"))
+		return
+	}
+
+	if fss.misbehave(w) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeFakeError(w, fakeError(http.StatusNotFound, "Service not found"))
+		return
+	}
+	parts = parts[1:]
+
+	query := r.URL.Query()
+	options := fakeOptions(query)
+
+	var v interface{}
+	var err error
+	status := http.StatusOK
+
+	switch {
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "me":
+		v, err = fss.Fake.CurrentUser()
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "artists":
+		v, err = fss.Fake.GetArtist(spotify.ID(parts[1]))
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "artists" && parts[2] == "albums":
+		v, err = fss.Fake.GetArtistAlbumsOpt(spotify.ID(parts[1]), options, nil)
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "albums":
+		v, err = fss.albums(fakeIDs(query))
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "albums":
+		v, err = fss.Fake.GetAlbum(spotify.ID(parts[1]))
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "albums" && parts[2] == "tracks":
+		limit, offset := DefaultPageLimit, 0
+		if options.Limit != nil {
+			limit = *options.Limit
+		}
+		if options.Offset != nil {
+			offset = *options.Offset
+		}
+		v, err = fss.Fake.GetAlbumTracksOpt(spotify.ID(parts[1]), limit, offset)
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "tracks":
+		var tracks []*spotify.FullTrack
+		tracks, err = fss.Fake.GetTracks(fakeIDs(query)...)
+		v = map[string]interface{}{"tracks": tracks}
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "users" && parts[2] == "playlists":
+		v, err = fss.Fake.GetPlaylistsForUserOpt(parts[1], options)
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "users" && parts[2] == "playlists":
+		v, err = fss.createPlaylist(parts[1], r)
+		status = http.StatusCreated
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		v, err = fss.Fake.GetPlaylistTracksOpt(spotify.ID(parts[1]), options, query.Get("fields"))
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		v, err = fss.addTracks(spotify.ID(parts[1]), r)
+		status = http.StatusCreated
+	case r.Method == "PUT" && len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		v, err = fss.replaceTracks(spotify.ID(parts[1]), r)
+		status = http.StatusCreated
+	case r.Method == "DELETE" && len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		v, err = fss.removeTracks(spotify.ID(parts[1]), r)
+	default:
+		err = fakeError(http.StatusNotFound, "Service not found")
+	}
+
+	if err != nil {
+		writeFakeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// The current user and playlists, everything outside the public catalog.
+func fakeNeedsUser(r *http.Request) bool {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	return path == "me" || strings.HasPrefix(path, "users/") || strings.HasPrefix(path, "playlists/")
+}
+
+// Immediately sends the browser back with a code, as though the user
+// approved.
+func (fss *FakeSpotifyServer) authorize(w http.ResponseWriter, r *http.Request) {
+	redirect, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+	if err != nil || redirect.String() == "" {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("code_challenge") != "" && query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "Unsupported code_challenge_method", http.StatusBadRequest)
+		return
+	}
+
+	fss.lock.Lock()
+	if fss.codes == nil {
+		fss.codes = make(map[string]fakeCode)
+	}
+	fss.issued += 1
+	code := fmt.Sprintf("fake-code-%d", fss.issued)
+	fss.codes[code] = fakeCode{challenge: query.Get("code_challenge"), scope: query.Get("scope")}
+	fss.lock.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", r.URL.Query().Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (fss *FakeSpotifyServer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.FormValue("grant_type") == "client_credentials" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": FakeAppToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+		return
+	}
+
+	scope := r.FormValue("scope")
+	if r.FormValue("grant_type") == "authorization_code" {
+		granted, err := fss.redeem(r.FormValue("code"), r.FormValue("code_verifier"))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":             "invalid_grant",
+				"error_description": err.Error(),
+			})
+			return
+		}
+		scope = granted
+	}
+
+	accessToken := FakeAccessToken
+	if r.FormValue("grant_type") == "refresh_token" {
+		if fss.RevokeRefreshTokens {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":             "invalid_grant",
+				"error_description": "Refresh token revoked",
+			})
+			return
+		}
+
+		fss.lock.Lock()
+		fss.refreshes += 1
+		accessToken = fmt.Sprintf("%s-%d", FakeAccessToken, fss.refreshes)
+		fss.lock.Unlock()
+	}
+
+	lifetime := fss.TokenLifetime
+	if lifetime <= 0 {
+		lifetime = time.Hour
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(lifetime / time.Second),
+		"refresh_token": "fake-refresh-token",
+		"scope":         scope,
+	})
+}
+
+// Codes are good once, and when authorize was given a PKCE challenge only
+// with the verifier it was made from. Returns the scopes the code was
+// authorized for.
+func (fss *FakeSpotifyServer) redeem(code, verifier string) (string, error) {
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+
+	issued, ok := fss.codes[code]
+	if !ok {
+		return "", fmt.Errorf("Invalid authorization code")
+	}
+	delete(fss.codes, code)
+
+	if issued.challenge != "" {
+		sum := sha256.Sum256([]byte(verifier))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != issued.challenge {
+			return "", fmt.Errorf("code_verifier was incorrect")
+		}
+	}
+
+	return issued.scope, nil
+}
+
+// The batch album endpoint.
+func (fss *FakeSpotifyServer) albums(ids []spotify.ID) (interface{}, error) {
+	albums, err := fss.Fake.GetAlbums(ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"albums": albums}, nil
+}
+
+func (fss *FakeSpotifyServer) createPlaylist(user string, r *http.Request) (interface{}, error) {
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fakeError(http.StatusBadRequest, "Error parsing JSON: %v", err)
+	}
+
+	return fss.Fake.CreatePlaylistForUser(user, body.Name, body.Description, body.Public)
+}
+
+func (fss *FakeSpotifyServer) addTracks(id spotify.ID, r *http.Request) (interface{}, error) {
+	uris := make([]string, 0)
+	if query := r.URL.Query().Get("uris"); query != "" {
+		uris = strings.Split(query, ",")
+	} else {
+		var body struct {
+			URIs []string `json:"uris"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, fakeError(http.StatusBadRequest, "Error parsing JSON: %v", err)
+		}
+		uris = body.URIs
+	}
+
+	snapshot, err := fss.Fake.AddTracksToPlaylist(id, fakeIDsFromURIs(uris)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"snapshot_id": snapshot}, nil
+}
+
+func (fss *FakeSpotifyServer) replaceTracks(id spotify.ID, r *http.Request) (interface{}, error) {
+	uris := make([]string, 0)
+	if query := r.URL.Query().Get("uris"); query != "" {
+		uris = strings.Split(query, ",")
+	}
+
+	if err := fss.Fake.ReplacePlaylistTracks(id, fakeIDsFromURIs(uris)...); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{}, nil
+}
+
+func (fss *FakeSpotifyServer) removeTracks(id spotify.ID, r *http.Request) (interface{}, error) {
+	var body struct {
+		Tracks []struct {
+			URI string `json:"uri"`
+		} `json:"tracks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fakeError(http.StatusBadRequest, "Error parsing JSON: %v", err)
+	}
+
+	uris := make([]string, 0)
+	for _, track := range body.Tracks {
+		uris = append(uris, track.URI)
+	}
+
+	snapshot, err := fss.Fake.RemoveTracksFromPlaylist(id, fakeIDsFromURIs(uris)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"snapshot_id": snapshot}, nil
+}
+
+func writeFakeError(w http.ResponseWriter, err error) {
+	e, ok := err.(spotify.Error)
+	if !ok {
+		e = spotify.Error{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": e})
+}
+
+func fakeOptions(query url.Values) *spotify.Options {
+	options := &spotify.Options{}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil {
+		options.Limit = &limit
+	}
+	if offset, err := strconv.Atoi(query.Get("offset")); err == nil {
+		options.Offset = &offset
+	}
+	return options
+}
+
+func fakeIDs(query url.Values) []spotify.ID {
+	ids := make([]spotify.ID, 0)
+	for _, id := range strings.Split(query.Get("ids"), ",") {
+		if id != "" {
+			ids = append(ids, spotify.ID(id))
+		}
+	}
+	return ids
+}
+
+func fakeIDsFromURIs(uris []string) []spotify.ID {
+	ids := make([]spotify.ID, 0)
+	for _, uri := range uris {
+		parts := strings.Split(uri, ":")
+		ids = append(ids, spotify.ID(parts[len(parts)-1]))
+	}
+	return ids
+}
+
+func RunFakeServer(args []string) error {
+	var catalog, listen, user string
+	var throttleEvery, failEvery int
+	var tokenLifetime time.Duration
+	var revokeRefreshTokens bool
+	fs := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	fs.StringVar(&catalog, "catalog", CacheDirectory, "directory laid out like .cache to serve the catalog from")
+	fs.StringVar(&listen, "listen", "localhost:9191", "address to listen on")
+	fs.StringVar(&user, "user", "jlewalle", "the user logging in")
+	fs.IntVar(&throttleEvery, "throttle-every", 0, "answer every Nth API request with a 429 and Retry-After")
+	fs.IntVar(&failEvery, "fail-every", 0, "answer every Nth API request with a 503")
+	fs.DurationVar(&tokenLifetime, "token-lifetime", time.Hour, "how long issued access tokens last")
+	fs.BoolVar(&revokeRefreshTokens, "revoke-refresh-tokens", false, "fail every token refresh with invalid_grant")
+	fs.Parse(args)
+
+	fake, err := LoadFakeSpotify(catalog, user)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("fake-server: %d artists, %d albums, %d tracks, %d playlists", len(fake.Artists), len(fake.Albums), len(fake.Tracks), len(fake.Playlists))
+	log.Printf("fake-server: listening on %s, run with --spotify-url http://%s", listen, listen)
+
+	return http.ListenAndServe(listen, &FakeSpotifyServer{
+		Fake:                fake,
+		ThrottleEvery:       throttleEvery,
+		FailEvery:           failEvery,
+		TokenLifetime:       tokenLifetime,
+		RevokeRefreshTokens: revokeRefreshTokens,
+	})
+}
@@ -0,0 +1,210 @@
+package beatles
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func TestFakeServerPaging(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+	album := fake.AddAlbum(artist.ID, "Long", "album", "1968-11-22", "day")
+	tracks := make([]spotify.ID, 0)
+	for i := 0; i < 30; i++ {
+		tracks = append(tracks, fake.AddTrack(album.ID, fmt.Sprintf("Song %d", i+1), 180000, 50).ID)
+	}
+	for i := 0; i < 45; i++ {
+		fake.AddPlaylist("tester", fmt.Sprintf("Playlist %d", i+1))
+	}
+	long := fake.AddPlaylist("tester", "Longer")
+	for i := 0; i < 9; i++ {
+		fake.AddTracksToPlaylist(long.ID, tracks...)
+	}
+
+	client, _ := fakeServerClient(t, fake)
+
+	limit, offset := 20, 40
+	page, err := client.GetPlaylistsForUserOpt("tester", &spotify.Options{Limit: &limit, Offset: &offset})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(page.Playlists) != 6 || page.Total != 46 || page.Offset != 40 || page.Limit != 20 {
+		t.Fatalf("expected the last 6 of 46 playlists, got %d of %d from %d", len(page.Playlists), page.Total, page.Offset)
+	}
+	if page.Playlists[0].Name != "Playlist 41" {
+		t.Fatalf("expected Playlist 41 first, got %s", page.Playlists[0].Name)
+	}
+
+	// Past the end is an empty page, over the maximum limit is refused.
+	offset = 100
+	page, err = client.GetPlaylistsForUserOpt("tester", &spotify.Options{Limit: &limit, Offset: &offset})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(page.Playlists) != 0 {
+		t.Fatalf("expected an empty page, got %d", len(page.Playlists))
+	}
+
+	limit = MaximumPlaylistsLimit + 1
+	_, err = client.GetPlaylistsForUserOpt("tester", &spotify.Options{Limit: &limit})
+	var spotifyErr spotify.Error
+	if !errors.As(err, &spotifyErr) || spotifyErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected a 400 for too large a limit, got %v", err)
+	}
+
+	playlist, err := GetPlaylistByTitle(client, "tester", "Longer")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if playlist == nil || playlist.ID != long.ID {
+		t.Fatalf("expected to find Longer on the third page, got %v", playlist)
+	}
+
+	playlistTracks, err := GetPlaylistTracks(client, long.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(playlistTracks) != 270 {
+		t.Fatalf("expected 270 playlist tracks, got %d", len(playlistTracks))
+	}
+
+	albumTracks, err := GetAlbumTracks(client, album.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(albumTracks) != 30 || albumTracks[29].ID != tracks[29] {
+		t.Fatalf("expected 30 album tracks in order, got %d", len(albumTracks))
+	}
+
+	// The batch endpoint has the first page of tracks, like the real one.
+	albums, err := client.GetAlbums(album.ID, "al-missing")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(albums) != 2 || albums[1] != nil {
+		t.Fatalf("expected a nil entry for the missing album, got %v", albums)
+	}
+	if albums[0].Tracks.Total != 30 || len(albums[0].Tracks.Tracks) != 30 {
+		t.Fatalf("expected all 30 tracks on the first page, got %d of %d", len(albums[0].Tracks.Tracks), albums[0].Tracks.Total)
+	}
+}
+
+func TestFakeServerSnapshots(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+	album := fake.AddAlbum(artist.ID, "Short", "album", "1964-07-10", "day")
+	first := fake.AddTrack(album.ID, "First", 180000, 50).ID
+	second := fake.AddTrack(album.ID, "Second", 180000, 50).ID
+
+	client, _ := fakeServerClient(t, fake)
+
+	listed := func() string {
+		t.Helper()
+		playlist, err := GetPlaylistByTitle(client, "tester", "Snapshots")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return playlist.SnapshotID
+	}
+
+	created, err := client.CreatePlaylistForUser("tester", "Snapshots", "", true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	before := listed()
+	if before == "" || before != created.SnapshotID {
+		t.Fatalf("expected the created snapshot %s to be listed, got %s", created.SnapshotID, before)
+	}
+
+	added, err := client.AddTracksToPlaylist(created.ID, first, second)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if added == before || added != listed() {
+		t.Fatalf("expected adding to give a new snapshot, listed, got %s after %s", added, before)
+	}
+
+	// Reading doesn't change anything.
+	if _, err := GetPlaylistTracks(client, created.ID); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if listed() != added {
+		t.Fatalf("expected reading to keep the snapshot")
+	}
+
+	removed, err := client.RemoveTracksFromPlaylist(created.ID, second)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if removed == added || removed != listed() {
+		t.Fatalf("expected removing to give a new snapshot, listed, got %s after %s", removed, added)
+	}
+
+	if tracks := fake.GetPlaylistByName("tester", "Snapshots").TrackIDs; len(tracks) != 1 || tracks[0] != first {
+		t.Fatalf("expected only the first track left, got %v", tracks)
+	}
+}
+
+func TestFakeServerOwnership(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+	album := fake.AddAlbum(artist.ID, "Short", "album", "1964-07-10", "day")
+	track := fake.AddTrack(album.ID, "First", 180000, 50).ID
+	theirs := fake.AddPlaylist("someone", "Theirs")
+
+	client, _ := fakeServerClient(t, fake)
+
+	var spotifyErr spotify.Error
+	if _, err := client.AddTracksToPlaylist(theirs.ID, track); !errors.As(err, &spotifyErr) || spotifyErr.Status != http.StatusForbidden {
+		t.Fatalf("expected a 403 changing someone else's playlist, got %v", err)
+	}
+	if _, err := client.CreatePlaylistForUser("someone", "Mine", "", true); !errors.As(err, &spotifyErr) || spotifyErr.Status != http.StatusForbidden {
+		t.Fatalf("expected a 403 creating a playlist for someone else, got %v", err)
+	}
+	if len(theirs.TrackIDs) != 0 {
+		t.Fatalf("expected their playlist untouched, got %v", theirs.TrackIDs)
+	}
+}
+
+func TestFakeServerUnauthorized(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+
+	server := httptest.NewServer(&FakeSpotifyServer{Fake: fake})
+	t.Cleanup(server.Close)
+
+	response, err := http.Get(server.URL + "/v1/artists/" + string(artist.ID))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected requests without a token to be refused, got %d", response.StatusCode)
+	}
+}
+
+func TestFakeServerAppToken(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+
+	fss := &FakeSpotifyServer{Fake: fake}
+	request := func(path string) int {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer "+FakeAppToken)
+		w := httptest.NewRecorder()
+		fss.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request("/v1/artists/" + string(artist.ID)); code != http.StatusOK {
+		t.Fatalf("expected the catalog to be readable as the app, got %d", code)
+	}
+	if code := request("/v1/users/tester/playlists"); code != http.StatusUnauthorized {
+		t.Fatalf("expected playlists to need a user, got %d", code)
+	}
+}
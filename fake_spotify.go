@@ -0,0 +1,601 @@
+package beatles
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify"
+)
+
+// Limits enforced by the Web API, requests exceeding them are rejected.
+const (
+	MaximumArtistAlbumsLimit   = 50
+	MaximumAlbumTracksLimit    = 50
+	MaximumTracksPerRequest    = 50
+	MaximumAlbumsPerRequest    = 20
+	MaximumPlaylistsLimit      = 50
+	MaximumPlaylistTracksLimit = 100
+	MaximumPlaylistWriteBatch  = 100
+	DefaultPageLimit           = 20
+)
+
+type FakePlaylist struct {
+	spotify.SimplePlaylist
+	Description string
+	TrackIDs    []spotify.ID
+	version     int
+}
+
+// An in-memory SpotifyClient. Every change to a playlist gets it a new
+// snapshot ID, the way the real service does.
+type FakeSpotify struct {
+	User         spotify.PrivateUser
+	Artists      map[spotify.ID]*spotify.FullArtist
+	Albums       map[spotify.ID]*spotify.FullAlbum
+	ArtistAlbums map[spotify.ID][]spotify.ID
+	Tracks       map[spotify.ID]*spotify.FullTrack
+	Playlists    []*FakePlaylist
+	lock         sync.Mutex
+	nextID       int
+}
+
+var _ SpotifyClient = (*FakeSpotify)(nil)
+
+func NewFakeSpotify(userID string) *FakeSpotify {
+	return &FakeSpotify{
+		User: spotify.PrivateUser{
+			User: spotify.User{
+				ID:          userID,
+				DisplayName: userID,
+			},
+		},
+		Artists:      make(map[spotify.ID]*spotify.FullArtist),
+		Albums:       make(map[spotify.ID]*spotify.FullAlbum),
+		ArtistAlbums: make(map[spotify.ID][]spotify.ID),
+		Tracks:       make(map[spotify.ID]*spotify.FullTrack),
+		Playlists:    make([]*FakePlaylist, 0),
+	}
+}
+
+func (fs *FakeSpotify) newID(prefix string) spotify.ID {
+	fs.nextID += 1
+	return spotify.ID(fmt.Sprintf("%s%020d", prefix, fs.nextID))
+}
+
+func fakeError(status int, format string, a ...interface{}) error {
+	return spotify.Error{
+		Status:  status,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+func fakeNotFound(kind string, id spotify.ID) error {
+	return fakeError(http.StatusNotFound, "%s %s not found", kind, id)
+}
+
+func fakePage(options *spotify.Options, maximum int) (limit, offset int, err error) {
+	limit = DefaultPageLimit
+	if options != nil && options.Limit != nil {
+		limit = *options.Limit
+	}
+	if options != nil && options.Offset != nil {
+		offset = *options.Offset
+	}
+	if limit < 1 || limit > maximum {
+		return 0, 0, fakeError(http.StatusBadRequest, "Invalid limit %d", limit)
+	}
+	if offset < 0 {
+		return 0, 0, fakeError(http.StatusBadRequest, "Invalid offset %d", offset)
+	}
+	return
+}
+
+func pageBounds(total, limit, offset int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	return offset, min(offset+limit, total)
+}
+
+func (fs *FakeSpotify) AddArtist(name string) *spotify.FullArtist {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	artist := &spotify.FullArtist{
+		SimpleArtist: spotify.SimpleArtist{
+			ID:   fs.newID("ar"),
+			Name: name,
+		},
+	}
+	artist.URI = spotify.URI("spotify:artist:" + string(artist.ID))
+	fs.putArtist(artist)
+	return artist
+}
+
+func (fs *FakeSpotify) PutArtist(artist *spotify.FullArtist) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.putArtist(artist)
+}
+
+func (fs *FakeSpotify) putArtist(artist *spotify.FullArtist) {
+	fs.Artists[artist.ID] = artist
+	if _, ok := fs.ArtistAlbums[artist.ID]; !ok {
+		fs.ArtistAlbums[artist.ID] = make([]spotify.ID, 0)
+	}
+}
+
+func (fs *FakeSpotify) AddAlbum(artistID spotify.ID, name, albumType, releaseDate, precision string) *spotify.FullAlbum {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	artist := fs.Artists[artistID]
+
+	album := &spotify.FullAlbum{
+		SimpleAlbum: spotify.SimpleAlbum{
+			ID:                   fs.newID("al"),
+			Name:                 name,
+			AlbumType:            albumType,
+			AlbumGroup:           albumType,
+			ReleaseDate:          releaseDate,
+			ReleaseDatePrecision: precision,
+			Artists:              []spotify.SimpleArtist{artist.SimpleArtist},
+		},
+	}
+	album.URI = spotify.URI("spotify:album:" + string(album.ID))
+	album.Tracks.Tracks = make([]spotify.SimpleTrack, 0)
+	fs.putAlbum(artistID, album)
+	return album
+}
+
+// Albums put without an artist are only reachable by ID, like an album that's
+// been pulled from an artist's discography.
+func (fs *FakeSpotify) PutAlbum(artistID spotify.ID, album *spotify.FullAlbum) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.putAlbum(artistID, album)
+}
+
+func (fs *FakeSpotify) putAlbum(artistID spotify.ID, album *spotify.FullAlbum) {
+	if existing, ok := fs.Albums[album.ID]; ok && len(album.Tracks.Tracks) == 0 {
+		album.Tracks = existing.Tracks
+	}
+	fs.Albums[album.ID] = album
+	if artistID == "" {
+		return
+	}
+	for _, id := range fs.ArtistAlbums[artistID] {
+		if id == album.ID {
+			return
+		}
+	}
+	fs.ArtistAlbums[artistID] = append(fs.ArtistAlbums[artistID], album.ID)
+}
+
+func (fs *FakeSpotify) AddTrack(albumID spotify.ID, name string, duration, popularity int) *spotify.FullTrack {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	album := fs.Albums[albumID]
+
+	track := &spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:          fs.newID("tr"),
+			Name:        name,
+			Duration:    duration,
+			DiscNumber:  1,
+			TrackNumber: len(album.Tracks.Tracks) + 1,
+			Artists:     album.Artists,
+		},
+		Album:      album.SimpleAlbum,
+		Popularity: popularity,
+	}
+	track.URI = spotify.URI("spotify:track:" + string(track.ID))
+	album.Tracks.Tracks = append(album.Tracks.Tracks, track.SimpleTrack)
+	album.Tracks.Total = len(album.Tracks.Tracks)
+	fs.Tracks[track.ID] = track
+	return track
+}
+
+func (fs *FakeSpotify) PutTrack(track *spotify.FullTrack) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.Tracks[track.ID] = track
+}
+
+// Replaces the track listing of an album, the tracks themselves are put
+// separately.
+func (fs *FakeSpotify) PutAlbumTracks(albumID spotify.ID, tracks []spotify.SimpleTrack) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	album, ok := fs.Albums[albumID]
+	if !ok {
+		album = &spotify.FullAlbum{
+			SimpleAlbum: spotify.SimpleAlbum{
+				ID: albumID,
+			},
+		}
+		fs.Albums[albumID] = album
+	}
+	album.Tracks.Tracks = tracks
+	album.Tracks.Total = len(tracks)
+}
+
+func (fs *FakeSpotify) AddPlaylist(owner, name string, ids ...spotify.ID) *FakePlaylist {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.addPlaylist(owner, name, "", true, ids)
+}
+
+func (fs *FakeSpotify) addPlaylist(owner, name, description string, public bool, ids []spotify.ID) *FakePlaylist {
+	playlist := &FakePlaylist{
+		SimplePlaylist: spotify.SimplePlaylist{
+			ID:       fs.newID("pl"),
+			Name:     name,
+			IsPublic: public,
+			Owner: spotify.User{
+				ID:          owner,
+				DisplayName: owner,
+			},
+		},
+		Description: description,
+		TrackIDs:    append(make([]spotify.ID, 0), ids...),
+	}
+	playlist.URI = spotify.URI("spotify:playlist:" + string(playlist.ID))
+	playlist.touch()
+	fs.Playlists = append(fs.Playlists, playlist)
+	return playlist
+}
+
+func (fs *FakeSpotify) PutPlaylist(playlist *FakePlaylist) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	for i, existing := range fs.Playlists {
+		if existing.ID == playlist.ID {
+			fs.Playlists[i] = playlist
+			return
+		}
+	}
+	fs.Playlists = append(fs.Playlists, playlist)
+}
+
+func (fp *FakePlaylist) touch() {
+	fp.version += 1
+	fp.SnapshotID = fmt.Sprintf("%s-%d", fp.ID, fp.version)
+	fp.SimplePlaylist.Tracks.Total = uint(len(fp.TrackIDs))
+}
+
+func (fs *FakeSpotify) playlist(id spotify.ID) *FakePlaylist {
+	for _, playlist := range fs.Playlists {
+		if playlist.ID == id {
+			return playlist
+		}
+	}
+	return nil
+}
+
+func (fs *FakeSpotify) GetPlaylistByName(owner, name string) *FakePlaylist {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	for _, playlist := range fs.Playlists {
+		if playlist.Owner.ID == owner && playlist.Name == name {
+			return playlist
+		}
+	}
+	return nil
+}
+
+func (fs *FakeSpotify) CurrentUser() (*spotify.PrivateUser, error) {
+	user := fs.User
+	return &user, nil
+}
+
+func (fs *FakeSpotify) GetArtist(id spotify.ID) (*spotify.FullArtist, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	artist, ok := fs.Artists[id]
+	if !ok {
+		return nil, fakeNotFound("artist", id)
+	}
+
+	copied := *artist
+	return &copied, nil
+}
+
+func (fs *FakeSpotify) GetArtistAlbumsOpt(id spotify.ID, options *spotify.Options, albumType *spotify.AlbumType) (*spotify.SimpleAlbumPage, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	ids, ok := fs.ArtistAlbums[id]
+	if !ok {
+		return nil, fakeNotFound("artist", id)
+	}
+
+	limit, offset, err := fakePage(options, MaximumArtistAlbumsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := pageBounds(len(ids), limit, offset)
+	page := &spotify.SimpleAlbumPage{
+		Albums: make([]spotify.SimpleAlbum, 0),
+	}
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(ids)
+	for _, albumID := range ids[start:end] {
+		page.Albums = append(page.Albums, fs.Albums[albumID].SimpleAlbum)
+	}
+
+	return page, nil
+}
+
+func (fs *FakeSpotify) GetAlbum(id spotify.ID) (*spotify.FullAlbum, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	album, ok := fs.Albums[id]
+	if !ok {
+		return nil, fakeNotFound("album", id)
+	}
+
+	return fs.albumWithFirstPage(album), nil
+}
+
+// Unknown albums come back as nil, like the real service.
+func (fs *FakeSpotify) GetAlbums(ids ...spotify.ID) ([]*spotify.FullAlbum, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if len(ids) > MaximumAlbumsPerRequest {
+		return nil, fakeError(http.StatusBadRequest, "Too many ids requested (%d)", len(ids))
+	}
+
+	albums := make([]*spotify.FullAlbum, 0)
+	for _, id := range ids {
+		if album, ok := fs.Albums[id]; ok {
+			albums = append(albums, fs.albumWithFirstPage(album))
+		} else {
+			albums = append(albums, nil)
+		}
+	}
+
+	return albums, nil
+}
+
+func (fs *FakeSpotify) albumWithFirstPage(album *spotify.FullAlbum) *spotify.FullAlbum {
+	copied := *album
+	start, end := pageBounds(len(album.Tracks.Tracks), MaximumAlbumTracksLimit, 0)
+	copied.Tracks.Tracks = append(make([]spotify.SimpleTrack, 0), album.Tracks.Tracks[start:end]...)
+	copied.Tracks.Limit = MaximumAlbumTracksLimit
+	return &copied
+}
+
+func (fs *FakeSpotify) GetAlbumTracksOpt(id spotify.ID, limit, offset int) (*spotify.SimpleTrackPage, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	album, ok := fs.Albums[id]
+	if !ok {
+		return nil, fakeNotFound("album", id)
+	}
+
+	limit, offset, err := fakePage(&spotify.Options{Limit: &limit, Offset: &offset}, MaximumAlbumTracksLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := album.Tracks.Tracks
+	start, end := pageBounds(len(tracks), limit, offset)
+	page := &spotify.SimpleTrackPage{
+		Tracks: append(make([]spotify.SimpleTrack, 0), tracks[start:end]...),
+	}
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(tracks)
+
+	return page, nil
+}
+
+// Unknown IDs come back as nil entries, as they do from the real service.
+func (fs *FakeSpotify) GetTracks(ids ...spotify.ID) ([]*spotify.FullTrack, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if len(ids) > MaximumTracksPerRequest {
+		return nil, fakeError(http.StatusBadRequest, "Too many ids requested (%d)", len(ids))
+	}
+
+	tracks := make([]*spotify.FullTrack, 0)
+	for _, id := range ids {
+		if track, ok := fs.Tracks[id]; ok {
+			copied := *track
+			tracks = append(tracks, &copied)
+		} else {
+			tracks = append(tracks, nil)
+		}
+	}
+
+	return tracks, nil
+}
+
+// Like the real service this returns playlists the user owns and follows, the
+// fake assumes a user follows everything they own.
+func (fs *FakeSpotify) GetPlaylistsForUserOpt(user string, options *spotify.Options) (*spotify.SimplePlaylistPage, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	limit, offset, err := fakePage(options, MaximumPlaylistsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make([]spotify.SimplePlaylist, 0)
+	for _, playlist := range fs.Playlists {
+		if playlist.Owner.ID == user {
+			owned = append(owned, playlist.SimplePlaylist)
+		}
+	}
+
+	start, end := pageBounds(len(owned), limit, offset)
+	page := &spotify.SimplePlaylistPage{
+		Playlists: owned[start:end],
+	}
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(owned)
+
+	return page, nil
+}
+
+func (fs *FakeSpotify) GetPlaylistTracksOpt(id spotify.ID, options *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	playlist := fs.playlist(id)
+	if playlist == nil {
+		return nil, fakeNotFound("playlist", id)
+	}
+
+	limit, offset, err := fakePage(options, MaximumPlaylistTracksLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := pageBounds(len(playlist.TrackIDs), limit, offset)
+	page := &spotify.PlaylistTrackPage{
+		Tracks: make([]spotify.PlaylistTrack, 0),
+	}
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(playlist.TrackIDs)
+	for _, trackID := range playlist.TrackIDs[start:end] {
+		track := spotify.PlaylistTrack{
+			AddedBy: playlist.Owner,
+		}
+		if full, ok := fs.Tracks[trackID]; ok {
+			track.Track = *full
+		} else {
+			track.Track.ID = trackID
+		}
+		page.Tracks = append(page.Tracks, track)
+	}
+
+	return page, nil
+}
+
+func (fs *FakeSpotify) CreatePlaylistForUser(user, name, description string, public bool) (*spotify.FullPlaylist, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if user != fs.User.ID {
+		return nil, fakeError(http.StatusForbidden, "You cannot create a playlist for another user")
+	}
+
+	playlist := fs.addPlaylist(user, name, description, public, nil)
+
+	return &spotify.FullPlaylist{
+		SimplePlaylist: playlist.SimplePlaylist,
+		Description:    description,
+	}, nil
+}
+
+func (fs *FakeSpotify) writablePlaylist(id spotify.ID, ids []spotify.ID) (*FakePlaylist, error) {
+	playlist := fs.playlist(id)
+	if playlist == nil {
+		return nil, fakeNotFound("playlist", id)
+	}
+
+	if playlist.Owner.ID != fs.User.ID {
+		return nil, fakeError(http.StatusForbidden, "You cannot modify a playlist you don't own")
+	}
+
+	if len(ids) > MaximumPlaylistWriteBatch {
+		return nil, fakeError(http.StatusBadRequest, "Too many tracks (%d)", len(ids))
+	}
+
+	return playlist, nil
+}
+
+func (fs *FakeSpotify) AddTracksToPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	playlist, err := fs.writablePlaylist(id, ids)
+	if err != nil {
+		return "", err
+	}
+
+	playlist.TrackIDs = append(playlist.TrackIDs, ids...)
+	playlist.touch()
+
+	return playlist.SnapshotID, nil
+}
+
+func (fs *FakeSpotify) ReplacePlaylistTracks(id spotify.ID, ids ...spotify.ID) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	playlist, err := fs.writablePlaylist(id, ids)
+	if err != nil {
+		return err
+	}
+
+	playlist.TrackIDs = append(make([]spotify.ID, 0), ids...)
+	playlist.touch()
+
+	return nil
+}
+
+// Removes every occurrence of each track.
+func (fs *FakeSpotify) RemoveTracksFromPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	playlist, err := fs.writablePlaylist(id, ids)
+	if err != nil {
+		return "", err
+	}
+
+	removing := make(map[spotify.ID]bool)
+	for _, id := range ids {
+		removing[id] = true
+	}
+
+	kept := make([]spotify.ID, 0)
+	for _, id := range playlist.TrackIDs {
+		if !removing[id] {
+			kept = append(kept, id)
+		}
+	}
+
+	playlist.TrackIDs = kept
+	playlist.touch()
+
+	return playlist.SnapshotID, nil
+}
+
+// A readable dump of playlists, handy when comparing state after a sync.
+func (fs *FakeSpotify) String() string {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	lines := make([]string, 0)
+	for _, playlist := range fs.Playlists {
+		lines = append(lines, fmt.Sprintf("%s (%s) %d tracks %s", playlist.Name, playlist.Owner.ID, len(playlist.TrackIDs), playlist.SnapshotID))
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
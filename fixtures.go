@@ -0,0 +1,291 @@
+package beatles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify"
+)
+
+const (
+	FixturesRecord = iota
+	FixturesReplay
+)
+
+const RedactedValue = "REDACTED"
+
+// Form values and JSON fields that carry credentials, they're never written to
+// a fixture.
+var sensitiveFields = []string{"access_token", "refresh_token", "code", "code_verifier", "client_id", "client_secret"}
+
+type Fixture struct {
+	Method      string
+	URL         string
+	RequestBody string
+	Status      int
+	Header      http.Header
+	Body        string
+}
+
+// An http.RoundTripper that either records request/response pairs to a
+// directory, or serves them back and fails on any request it hasn't seen.
+// Identical requests are numbered in the order they're made, so a GET that's
+// repeated after a write replays the response that followed the write.
+type FixtureTransport struct {
+	Mode      int
+	Directory string
+	Base      http.RoundTripper
+	lock      sync.Mutex
+	seen      map[string]int
+	served    map[string]bool
+}
+
+func NewFixtureTransport(mode int, directory string, base http.RoundTripper) (*FixtureTransport, error) {
+	if mode == FixturesRecord {
+		if err := os.MkdirAll(directory, 0755); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(directory); err != nil {
+		return nil, fmt.Errorf("Error opening fixtures: %v", err)
+	}
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &FixtureTransport{
+		Mode:      mode,
+		Directory: directory,
+		Base:      base,
+		seen:      make(map[string]int),
+		served:    make(map[string]bool),
+	}, nil
+}
+
+var unsafeFixtureChars = regexp.MustCompile("[^A-Za-z0-9]+")
+
+func (ft *FixtureTransport) fileName(method, requestUrl, body string) string {
+	key := method + " " + requestUrl + " " + body
+
+	ft.lock.Lock()
+	ft.seen[key] += 1
+	number := ft.seen[key]
+	ft.lock.Unlock()
+
+	u, err := url.Parse(requestUrl)
+	readable := requestUrl
+	if err == nil {
+		readable = u.Host + u.Path
+	}
+	readable = strings.Trim(unsafeFixtureChars.ReplaceAllString(readable, "-"), "-")
+	if len(readable) > 80 {
+		readable = readable[len(readable)-80:]
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%d.json", method, readable, checksum([]byte(key))[:12], number)
+}
+
+func (ft *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+		body = scrubRequestBody(string(data))
+	}
+
+	requestUrl := req.URL.String()
+	path := filepath.Join(ft.Directory, ft.fileName(req.Method, requestUrl, body))
+
+	if ft.Mode == FixturesReplay {
+		return ft.replay(req, path)
+	}
+
+	return ft.record(req, path, body)
+}
+
+func (ft *FixtureTransport) record(req *http.Request, path, body string) (*http.Response, error) {
+	res, err := ft.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	header := make(http.Header)
+	for key, values := range res.Header {
+		if key != "Set-Cookie" && key != "Content-Length" {
+			header[key] = values
+		}
+	}
+
+	fixture := Fixture{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: body,
+		Status:      res.StatusCode,
+		Header:      header,
+		Body:        scrubResponseBody(data),
+	}
+
+	file, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := WriteFileAtomic(path, file, 0644); err != nil {
+		return nil, fmt.Errorf("Error saving fixture: %v", err)
+	}
+
+	return res, nil
+}
+
+func (ft *FixtureTransport) replay(req *http.Request, path string) (*http.Response, error) {
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("fixtures: unexpected request %s %s (no %s)", req.Method, req.URL, filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(file, &fixture); err != nil {
+		return nil, fmt.Errorf("fixtures: error unmarshalling %s: %v", path, err)
+	}
+
+	ft.lock.Lock()
+	ft.served[filepath.Base(path)] = true
+	ft.lock.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode:    fixture.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        fixture.Header,
+		Body:          ioutil.NopCloser(strings.NewReader(fixture.Body)),
+		ContentLength: int64(len(fixture.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Fixtures in the directory that were never requested, useful for noticing a
+// replay that stopped short.
+func (ft *FixtureTransport) Unused() ([]string, error) {
+	infos, err := ioutil.ReadDir(ft.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	unused := make([]string, 0)
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".json") && !ft.served[info.Name()] {
+			unused = append(unused, info.Name())
+		}
+	}
+
+	sort.Strings(unused)
+
+	return unused, nil
+}
+
+func scrubRequestBody(body string) string {
+	values, err := url.ParseQuery(body)
+	if err != nil || len(values) == 0 {
+		return body
+	}
+
+	scrubbed := false
+	for _, field := range sensitiveFields {
+		if _, ok := values[field]; ok {
+			values.Set(field, RedactedValue)
+			scrubbed = true
+		}
+	}
+
+	if !scrubbed {
+		return body
+	}
+
+	return values.Encode()
+}
+
+func scrubResponseBody(data []byte) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return string(data)
+	}
+
+	scrubbed := false
+	for _, field := range sensitiveFields {
+		if _, ok := fields[field]; ok {
+			fields[field] = RedactedValue
+			scrubbed = true
+		}
+	}
+
+	if !scrubbed {
+		return string(data)
+	}
+
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return string(data)
+	}
+
+	return string(redacted)
+}
+
+// A client that never authenticates, every request is served from fixtures.
+func NewReplayClient(ctx context.Context, directory string) (*spotify.Client, *FixtureTransport, error) {
+	transport, err := NewFixtureTransport(FixturesReplay, directory, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := spotify.NewClient(&http.Client{Transport: &contextTransport{ctx: ctx, base: transport}})
+
+	log.Printf("Replaying Spotify requests from %s", directory)
+
+	return &client, transport, nil
+}
+
+// Installs a recording transport beneath clients created by NewSpotifyClient,
+// token refreshes included.
+func RecordFixtures(directory string) (*FixtureTransport, error) {
+	transport, err := NewFixtureTransport(FixturesRecord, directory, spotifyTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	spotifyTransport = transport
+
+	log.Printf("Recording Spotify requests to %s", directory)
+
+	return transport, nil
+}
@@ -0,0 +1,340 @@
+package beatles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+var recordFixtures = flag.Bool("record-fixtures", false, "re-record testdata/fixtures from a fake Spotify server")
+
+// The catalog the fixtures were recorded from. Everything's sized to need more
+// than one page: 25 albums, one of them with 60 tracks, and 25 playlists, one
+// with 120 tracks.
+func newFixtureFake() *FakeSpotify {
+	fake := NewFakeSpotify("fixture-user")
+	artist := fake.AddArtist("The Fixtures")
+
+	tracks := make([]spotify.ID, 0)
+	for i := 0; i < 25; i++ {
+		album := fake.AddAlbum(artist.ID, fmt.Sprintf("Album %d", i+1), "album", fmt.Sprintf("%d-01-01", 1963+i), "day")
+		number := 1
+		if i == 0 {
+			number = 60
+		}
+		for j := 0; j < number; j++ {
+			track := fake.AddTrack(album.ID, fmt.Sprintf("Song %d.%d", i+1, j+1), 180000, 50)
+			tracks = append(tracks, track.ID)
+		}
+	}
+
+	for i := 0; i < 25; i++ {
+		fake.AddPlaylist("fixture-user", fmt.Sprintf("Playlist %d", i+1), tracks[i])
+	}
+	fake.AddPlaylist("fixture-user", "Long", append(tracks[:60:60], tracks[:60]...)...)
+
+	return fake
+}
+
+// A client replaying testdata/fixtures/<test>, or with -record-fixtures one
+// recording them afresh from a fake server. Fails the test when a replay
+// leaves fixtures unused. The directory's absolute, tests change into
+// directories of their own.
+func fixtureClient(t *testing.T) *spotify.Client {
+	t.Helper()
+
+	directory, err := filepath.Abs(filepath.Join("testdata", "fixtures", t.Name()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !*recordFixtures {
+		client, transport, err := NewReplayClient(context.Background(), directory)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		t.Cleanup(func() {
+			unused, err := transport.Unused()
+			if err != nil {
+				t.Errorf("%v", err)
+			}
+			if len(unused) > 0 {
+				t.Errorf("unused fixtures: %v", unused)
+			}
+		})
+		return client
+	}
+
+	if err := os.RemoveAll(directory); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The fixtures don't keep request headers, the token's added beneath them.
+	server := httptest.NewServer(&FakeSpotifyServer{Fake: newFixtureFake()})
+	t.Cleanup(server.Close)
+
+	transport, err := NewFixtureTransport(FixturesRecord, directory, fakeServerTransport(t, server))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	client := spotify.NewClient(&http.Client{Transport: transport})
+	return &client
+}
+
+// The fake hands out IDs in order, so they're the same as when recorded.
+func fixtureArtist(t *testing.T, cacher *SpotifyCacher) spotify.ID {
+	t.Helper()
+
+	ids := make([]spotify.ID, 0)
+	for id := range newFixtureFake().Artists {
+		ids = append(ids, id)
+	}
+
+	artist, err := cacher.GetArtist(ids[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if artist.Name != "The Fixtures" {
+		t.Fatalf("expected The Fixtures, got %s", artist.Name)
+	}
+	return artist.ID
+}
+
+// A user with 25 playlists, more than a page of them, the last of which has
+// 120 tracks.
+func fixtureHandler() http.Handler {
+	playlists := make([]spotify.SimplePlaylist, 0)
+	for i := 0; i < 25; i++ {
+		playlists = append(playlists, spotify.SimplePlaylist{ID: spotify.ID(fmt.Sprintf("pl-%d", i+1)), Name: fmt.Sprintf("Playlist %d", i+1)})
+	}
+	playlists[24].Name = "Long"
+
+	tracks := make([]spotify.PlaylistTrack, 0)
+	for i := 0; i < 120; i++ {
+		tracks = append(tracks, spotify.PlaylistTrack{Track: spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: spotify.ID(fmt.Sprintf("tr-%d", i+1))}}})
+	}
+
+	page := func(r *http.Request, total int) (int, int) {
+		var limit, offset int
+		fmt.Sscan(r.URL.Query().Get("limit"), &limit)
+		fmt.Sscan(r.URL.Query().Get("offset"), &offset)
+		return min(offset, total), min(offset+limit, total)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users/fixture-user/playlists", func(w http.ResponseWriter, r *http.Request) {
+		from, to := page(r, len(playlists))
+		json.NewEncoder(w).Encode(spotify.SimplePlaylistPage{Playlists: playlists[from:to]})
+	})
+	mux.HandleFunc("/v1/playlists/pl-25/tracks", func(w http.ResponseWriter, r *http.Request) {
+		from, to := page(r, len(tracks))
+		json.NewEncoder(w).Encode(spotify.PlaylistTrackPage{Tracks: tracks[from:to]})
+	})
+	return mux
+}
+
+// Looks up the long playlist and its tracks, one page at a time.
+func fetchLongPlaylist(t *testing.T, client *spotify.Client) []spotify.PlaylistTrack {
+	t.Helper()
+
+	playlist, err := GetPlaylistByTitle(client, "fixture-user", "Long")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if playlist == nil {
+		t.Fatalf("expected the Long playlist")
+	}
+
+	tracks, err := GetPlaylistTracks(client, playlist.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return tracks
+}
+
+func TestFixturesRecordReplay(t *testing.T) {
+	directory := t.TempDir()
+
+	recorder, err := NewFixtureTransport(FixturesRecord, directory, testSpotifyTransport(t, fixtureHandler()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	recording := spotify.NewClient(&http.Client{Transport: recorder})
+	recorded := fetchLongPlaylist(t, &recording)
+	if len(recorded) != 120 {
+		t.Fatalf("expected 120 tracks, got %d", len(recorded))
+	}
+
+	// Replayed without the server, every recorded response is used.
+	client, replayer, err := NewReplayClient(context.Background(), directory)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	replayed := fetchLongPlaylist(t, client)
+	if len(replayed) != len(recorded) || replayed[119].Track.ID != recorded[119].Track.ID {
+		t.Fatalf("expected the recorded tracks, got %d", len(replayed))
+	}
+	unused, err := replayer.Unused()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(unused) > 0 {
+		t.Fatalf("expected every fixture to be used, unused %v", unused)
+	}
+
+	// Anything that wasn't recorded fails.
+	if _, err := GetPlaylistByTitle(client, "another-user", "Long"); err == nil {
+		t.Fatalf("expected a request that wasn't recorded to fail")
+	}
+}
+
+func TestFixturesScrubbed(t *testing.T) {
+	request := scrubRequestBody("grant_type=refresh_token&refresh_token=secret&client_id=app")
+	if strings.Contains(request, "secret") || strings.Contains(request, "app") || !strings.Contains(request, "grant_type=refresh_token") {
+		t.Fatalf("expected the credentials to be redacted, got %s", request)
+	}
+
+	response := scrubResponseBody([]byte(`{"access_token":"secret","expires_in":3600}`))
+	if strings.Contains(response, "secret") || !strings.Contains(response, RedactedValue) || !strings.Contains(response, "expires_in") {
+		t.Fatalf("expected the token to be redacted, got %s", response)
+	}
+
+	// Anything else is left as it is.
+	if body := scrubRequestBody(`{"name":"Long"}`); body != `{"name":"Long"}` {
+		t.Fatalf("expected the body as it was, got %s", body)
+	}
+}
+
+func TestReplayGetPlaylistByTitle(t *testing.T) {
+	client := fixtureClient(t)
+
+	// On the second page of 20.
+	playlist, err := GetPlaylistByTitle(client, "fixture-user", "Playlist 23")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if playlist == nil || playlist.Name != "Playlist 23" {
+		t.Fatalf("expected Playlist 23, got %v", playlist)
+	}
+
+	missing, err := GetPlaylistByTitle(client, "fixture-user", "Nothing")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if missing != nil {
+		t.Fatalf("expected no playlist, got %s", missing.Name)
+	}
+}
+
+func TestReplayGetPlaylistTracks(t *testing.T) {
+	client := fixtureClient(t)
+
+	playlist, err := GetPlaylistByTitle(client, "fixture-user", "Long")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if playlist == nil {
+		t.Fatalf("expected the Long playlist")
+	}
+
+	tracks, err := GetPlaylistTracks(client, playlist.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(tracks) != 120 {
+		t.Fatalf("expected 120 tracks, got %d", len(tracks))
+	}
+	if tracks[0].Track.ID != tracks[60].Track.ID {
+		t.Fatalf("expected the second half to repeat the first")
+	}
+}
+
+func TestReplayCacher(t *testing.T) {
+	client := fixtureClient(t)
+
+	// Fetching expects the cache to be there, it's checked in.
+	if err := os.MkdirAll(useCacheDirectory(t), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	cacher := NewSpotifyCacher(client, false)
+	artistID := fixtureArtist(t, cacher)
+
+	albums, err := cacher.GetArtistAlbums(artistID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(albums) != 25 {
+		t.Fatalf("expected 25 albums, got %d", len(albums))
+	}
+
+	ids := make([]spotify.ID, 0)
+	for _, album := range albums {
+		ids = append(ids, album.ID)
+	}
+	if err := cacher.PrefetchAlbums(ids); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	trackIDs := make([]spotify.ID, 0)
+	for _, album := range albums {
+		tracks, err := cacher.GetAlbumTracks(album.ID)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, track := range tracks {
+			trackIDs = append(trackIDs, track.ID)
+		}
+	}
+	if len(trackIDs) != 84 {
+		t.Fatalf("expected 84 tracks, got %d", len(trackIDs))
+	}
+
+	for i := 0; i < len(trackIDs); i += 50 {
+		tracks, err := cacher.GetTracks(trackIDs[i:min(i+50, len(trackIDs))])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(tracks) != min(50, len(trackIDs)-i) {
+			t.Fatalf("expected every track, got %d", len(tracks))
+		}
+	}
+
+	playlists, err := cacher.GetPlaylists("fixture-user")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(playlists.Playlists) != 26 {
+		t.Fatalf("expected 26 playlists, got %d", len(playlists.Playlists))
+	}
+
+	// Everything's cached now, offline nothing's missing.
+	offline := NewSpotifyCacher(nil, true)
+	if _, err := offline.GetArtist(artistID); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, album := range albums {
+		if _, err := offline.GetAlbumTracks(album.ID); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if _, err := offline.GetTracks(trackIDs); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := offline.GetPlaylists("fixture-user"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if missing := offline.Missing(); len(missing) > 0 {
+		t.Fatalf("expected everything cached, missing %v", missing)
+	}
+}
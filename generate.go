@@ -0,0 +1,408 @@
+package beatles
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zmb3/spotify"
+)
+
+type GeneratorOptions struct {
+	Seed           int64
+	Scale          int
+	ArtistName     string
+	User           string
+	StudioAlbums   int
+	TracksPerAlbum int
+	Compilations   int
+	Remasters      float64
+	LiveAlbums     int
+	Medleys        float64
+	Duplicates     float64
+	Punctuation    float64
+	PartialDates   float64
+	ShortTracks    float64
+	Excluded       int
+}
+
+func (o *GeneratorOptions) AddFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&o.Seed, "seed", 1, "random seed, the same seed always generates the same catalog")
+	fs.IntVar(&o.Scale, "scale", 1, "multiplies the number of albums of every kind")
+	fs.StringVar(&o.ArtistName, "artist-name", "the synthetics", "name of the generated artist")
+	fs.StringVar(&o.User, "user", "jlewalle", "owner of the generated exclusion playlists")
+	fs.IntVar(&o.StudioAlbums, "studio-albums", 13, "studio albums, each introducing new songs")
+	fs.IntVar(&o.TracksPerAlbum, "tracks-per-album", 13, "average tracks on a studio album")
+	fs.IntVar(&o.Compilations, "compilations", 10, "compilations of previously released songs")
+	fs.Float64Var(&o.Remasters, "remasters", 0.8, "fraction of studio albums re-released remastered")
+	fs.IntVar(&o.LiveAlbums, "live-albums", 4, "live albums")
+	fs.Float64Var(&o.Medleys, "medleys", 0.02, "fraction of compilation and live tracks that are medleys")
+	fs.Float64Var(&o.Duplicates, "duplicates", 0.03, "fraction of songs titled like an earlier, different song, 'Help' and 'Help Me' say")
+	fs.Float64Var(&o.Punctuation, "punctuation", 0.15, "fraction of song titles with odd punctuation")
+	fs.Float64Var(&o.PartialDates, "partial-dates", 0.1, "fraction of albums with only a year or month for a release date")
+	fs.Float64Var(&o.ShortTracks, "short-tracks", 0.03, "fraction of songs under a minute long")
+	fs.IntVar(&o.Excluded, "excluded-albums", 3, "compilations to list as excluded albums")
+}
+
+var generatorWords = []string{
+	"Love", "Girl", "Sun", "Road", "Yellow", "Walrus", "Day", "Night", "Help", "Home", "Heart", "Window",
+	"Rain", "Glass", "Ticket", "Ride", "Garden", "Octopus", "Honey", "Pie", "Taxman", "Dream", "Hello",
+	"Goodbye", "Fool", "Hill", "Martha", "Dear", "Prudence", "Piggies", "Rocky", "Mountain", "Savoy",
+	"Truffle", "Birthday", "Revolution", "Blackbird", "Julia", "Penny", "Lane", "Strawberry", "Fields",
+	"Lady", "Madonna", "Paperback", "Writer", "Rain", "Nowhere", "Man", "Michelle", "Tomorrow", "Never",
+	"Knows", "Eleanor", "Submarine", "Across", "Universe", "Something", "Because", "Carry", "Weight",
+}
+
+var generatorPunctuation = []func(title string, r *rand.Rand) string{
+	func(title string, r *rand.Rand) string { return title + "!" },
+	func(title string, r *rand.Rand) string { return title + "?" },
+	func(title string, r *rand.Rand) string { return "Mr. " + title },
+	func(title string, r *rand.Rand) string { return "Sgt. " + title },
+	func(title string, r *rand.Rand) string { return title + " (Reprise)" },
+	func(title string, r *rand.Rand) string { return strings.Replace(title, " ", " & ", 1) },
+	func(title string, r *rand.Rand) string { return title + "'s" },
+	func(title string, r *rand.Rand) string { return "Back In The U.S.S.R" },
+	func(title string, r *rand.Rand) string {
+		return title + " / " + generatorWords[r.Intn(len(generatorWords))]
+	},
+	func(title string, r *rand.Rand) string { return "When I'm Sixty Four" },
+}
+
+// Titles of different songs that are close to an earlier song's, which
+// grouping mustn't take for another recording of it.
+var generatorNearTitles = []func(title string, r *rand.Rand) string{
+	func(title string, r *rand.Rand) string {
+		return title + " " + generatorWords[r.Intn(len(generatorWords))]
+	},
+	func(title string, r *rand.Rand) string { return "The " + title },
+	func(title string, r *rand.Rand) string { return title + " No. 2" },
+	func(title string, r *rand.Rand) string { return title + " (Reprise)" },
+}
+
+var generatorVersions = []string{
+	"Remastered 2009", "2015 Stereo Mix", "Mono", "Take 2", "Anthology 3 Version", "Demo", "Single Version",
+}
+
+type generatedSong struct {
+	Title    string
+	Duration int
+}
+
+type generator struct {
+	options GeneratorOptions
+	random  *rand.Rand
+	fake    *FakeSpotify
+	artist  *spotify.FullArtist
+	songs   []generatedSong
+	year    int
+	titles  map[string]bool
+}
+
+// Fills a fake with one synthetic artist, whose discography has the same
+// shape as the real one: studio albums introducing songs and then remasters,
+// live albums and compilations re-recording or re-releasing them.
+func GenerateDiscography(options GeneratorOptions, fake *FakeSpotify) (artist *spotify.FullArtist, excluded []spotify.ID) {
+	g := &generator{
+		options: options,
+		random:  rand.New(rand.NewSource(options.Seed)),
+		fake:    fake,
+		songs:   make([]generatedSong, 0),
+		year:    1963,
+		titles:  make(map[string]bool),
+	}
+
+	g.artist = fake.AddArtist(options.ArtistName)
+
+	scale := options.Scale
+	if scale < 1 {
+		scale = 1
+	}
+
+	studio := make([]*spotify.FullAlbum, 0)
+	for i := 0; i < options.StudioAlbums*scale; i++ {
+		studio = append(studio, g.studioAlbum(i))
+	}
+
+	for _, album := range studio {
+		if g.random.Float64() < options.Remasters {
+			g.remaster(album)
+		}
+	}
+
+	for i := 0; i < options.LiveAlbums*scale; i++ {
+		g.liveAlbum(i)
+	}
+
+	compilations := make([]*spotify.FullAlbum, 0)
+	for i := 0; i < options.Compilations*scale; i++ {
+		compilations = append(compilations, g.compilation(i))
+	}
+
+	excluded = make([]spotify.ID, 0)
+	for i := 0; i < options.Excluded && i < len(compilations); i++ {
+		excluded = append(excluded, compilations[i].ID)
+	}
+
+	return g.artist, excluded
+}
+
+func (g *generator) chance(p float64) bool {
+	return g.random.Float64() < p
+}
+
+func (g *generator) releaseDate() (string, string) {
+	g.year += g.random.Intn(2)
+	month := 1 + g.random.Intn(12)
+	day := 1 + g.random.Intn(28)
+
+	if g.chance(g.options.PartialDates) {
+		if g.chance(0.5) {
+			return fmt.Sprintf("%04d", g.year), "year"
+		}
+		return fmt.Sprintf("%04d-%02d", g.year, month), "month"
+	}
+
+	return fmt.Sprintf("%04d-%02d-%02d", g.year, month, day), "day"
+}
+
+func (g *generator) title() string {
+	if len(g.songs) > 0 && g.chance(g.options.Duplicates) {
+		earlier := g.songs[g.random.Intn(len(g.songs))].Title
+		title := generatorNearTitles[g.random.Intn(len(generatorNearTitles))](earlier, g.random)
+		if !g.titles[title] {
+			g.titles[title] = true
+			return title
+		}
+	}
+
+	for {
+		words := 1 + g.random.Intn(4)
+		parts := make([]string, 0)
+		for i := 0; i < words; i++ {
+			parts = append(parts, generatorWords[g.random.Intn(len(generatorWords))])
+		}
+
+		title := strings.Join(parts, " ")
+		if g.chance(g.options.Punctuation) {
+			title = generatorPunctuation[g.random.Intn(len(generatorPunctuation))](title, g.random)
+		}
+
+		if !g.titles[title] {
+			g.titles[title] = true
+			return title
+		}
+	}
+}
+
+func (g *generator) duration() int {
+	if g.chance(g.options.ShortTracks) {
+		return 10*1000 + g.random.Intn(50*1000)
+	}
+	return 90*1000 + g.random.Intn(240*1000)
+}
+
+func (g *generator) popularity() int {
+	return g.random.Intn(80)
+}
+
+func (g *generator) album(name, albumType string) *spotify.FullAlbum {
+	date, precision := g.releaseDate()
+	return g.fake.AddAlbum(g.artist.ID, name, albumType, date, precision)
+}
+
+func (g *generator) studioAlbum(number int) *spotify.FullAlbum {
+	album := g.album(fmt.Sprintf("Studio Album %d", number+1), "album")
+
+	tracks := g.options.TracksPerAlbum/2 + g.random.Intn(g.options.TracksPerAlbum+1)
+	for i := 0; i < tracks; i++ {
+		song := generatedSong{
+			Title:    g.title(),
+			Duration: g.duration(),
+		}
+		g.songs = append(g.songs, song)
+		g.fake.AddTrack(album.ID, song.Title, song.Duration, g.popularity())
+	}
+
+	return album
+}
+
+func (g *generator) remaster(original *spotify.FullAlbum) {
+	album := g.album(original.Name+" (Remastered)", "album")
+	for _, track := range original.Tracks.Tracks {
+		g.fake.AddTrack(album.ID, track.Name+" - Remastered 2009", track.Duration, g.popularity())
+	}
+}
+
+func (g *generator) medley() string {
+	parts := make([]string, 0)
+	for i := 0; i < 2+g.random.Intn(3); i++ {
+		parts = append(parts, g.songs[g.random.Intn(len(g.songs))].Title)
+	}
+	return strings.Join(parts, " / ") + " - Medley"
+}
+
+func (g *generator) liveAlbum(number int) {
+	if len(g.songs) == 0 {
+		return
+	}
+
+	album := g.album(fmt.Sprintf("Live At The Synthetic Bowl %d", number+1), "album")
+	for i := 0; i < 10+g.random.Intn(20); i++ {
+		if g.chance(g.options.Medleys) {
+			g.fake.AddTrack(album.ID, g.medley(), 300*1000+g.random.Intn(300*1000), g.popularity())
+			continue
+		}
+
+		song := g.songs[g.random.Intn(len(g.songs))]
+		name := song.Title + " - Live"
+		if g.chance(0.2) {
+			name = song.Title + " - Live At The BBC"
+		}
+		g.fake.AddTrack(album.ID, name, song.Duration+g.random.Intn(30*1000), g.popularity())
+	}
+}
+
+func (g *generator) compilation(number int) *spotify.FullAlbum {
+	album := g.album(fmt.Sprintf("Greatest Synthetics Vol. %d", number+1), "compilation")
+	if len(g.songs) == 0 {
+		return album
+	}
+
+	for i := 0; i < 15+g.random.Intn(20); i++ {
+		if g.chance(g.options.Medleys) {
+			g.fake.AddTrack(album.ID, g.medley(), 300*1000+g.random.Intn(300*1000), g.popularity())
+			continue
+		}
+
+		song := g.songs[g.random.Intn(len(g.songs))]
+		name := song.Title
+		if g.chance(0.5) {
+			name = song.Title + " - " + generatorVersions[g.random.Intn(len(generatorVersions))]
+		}
+		g.fake.AddTrack(album.ID, name, song.Duration, g.popularity())
+	}
+
+	return album
+}
+
+// Writes a fake out in the layout of .cache, readable by --offline runs and
+// fake-server.
+func WriteFakeCatalog(fake *FakeSpotify, directory string) error {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+
+	cacher := &SpotifyCacher{}
+	save := func(v interface{}, name string, a ...interface{}) error {
+		return cacher.save(filepath.Join(directory, fmt.Sprintf(name, a...)), v)
+	}
+
+	for id, artist := range fake.Artists {
+		if err := save(artist, "artist-%s.json", id); err != nil {
+			return err
+		}
+
+		albums := make([]spotify.SimpleAlbum, 0)
+		for _, albumID := range fake.ArtistAlbums[id] {
+			albums = append(albums, fake.Albums[albumID].SimpleAlbum)
+		}
+
+		if err := save(albums, "artist-albums-%s.json", id); err != nil {
+			return err
+		}
+	}
+
+	for id, album := range fake.Albums {
+		if err := save(album, "album-%s.json", id); err != nil {
+			return err
+		}
+
+		if err := save(album.Tracks.Tracks, "album-tracks-%s.json", id); err != nil {
+			return err
+		}
+	}
+
+	for id, track := range fake.Tracks {
+		if err := save(track, "track-%s.json", id); err != nil {
+			return err
+		}
+	}
+
+	users := make(map[string]*PlaylistSet)
+	for _, fp := range fake.Playlists {
+		set, ok := users[fp.Owner.ID]
+		if !ok {
+			set = &PlaylistSet{Playlists: make([]Playlist, 0)}
+			users[fp.Owner.ID] = set
+		}
+
+		set.Playlists = append(set.Playlists, Playlist{
+			ID:         fp.ID,
+			User:       fp.Owner.ID,
+			Name:       fp.Name,
+			SnapshotID: fp.SnapshotID,
+		})
+
+		tracks := make([]spotify.PlaylistTrack, 0)
+		for _, trackID := range fp.TrackIDs {
+			tracks = append(tracks, spotify.PlaylistTrack{Track: *fake.Tracks[trackID]})
+		}
+
+		if err := save(&CachedPlaylistTracks{SnapshotID: fp.SnapshotID, Tracks: tracks}, "playlist-%s.json", fp.ID); err != nil {
+			return err
+		}
+	}
+
+	for user, set := range users {
+		if err := save(set, "playlists-%s.json", user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func RunGenerate(args []string) error {
+	var options GeneratorOptions
+	var output string
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	options.AddFlags(fs)
+	fs.StringVar(&output, "output", ".cache-synthetic", "directory to write the catalog to, laid out like .cache")
+	fs.Parse(args)
+
+	fake := NewFakeSpotify(options.User)
+	artist, excluded := GenerateDiscography(options, fake)
+
+	// A handful of tracks excluded by hand, the way exclusion playlists are
+	// used for real.
+	ids := make([]spotify.ID, 0)
+	for id := range fake.Tracks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	random := rand.New(rand.NewSource(options.Seed))
+	picked := make([]spotify.ID, 0)
+	for i := 0; i < len(ids)/50; i++ {
+		picked = append(picked, ids[random.Intn(len(ids))])
+	}
+	fake.AddPlaylist(options.User, options.ArtistName+" (excluded)", picked...)
+
+	if err := WriteFakeCatalog(fake, output); err != nil {
+		return err
+	}
+
+	excludedStrings := make([]string, 0)
+	for _, id := range excluded {
+		excludedStrings = append(excludedStrings, string(id))
+	}
+
+	log.Printf("Generated %d albums, %d tracks for '%s' in %s", len(fake.Albums), len(fake.Tracks), artist.Name, output)
+	log.Printf("Run with: --artist-id %s --artist-name '%s' --excluded-albums %s", artist.ID, artist.Name, strings.Join(excludedStrings, ","))
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package beatles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Catalogs generated at a scale, fetched once and shared by every test and
+// benchmark that asks for that scale.
+var generatedCatalogs = make(map[int]*Catalog)
+
+// The scale is how many times the default discography, which is about the
+// size of the real one. It's fetched into a cache in a directory of its own.
+func generatedCatalog(tb testing.TB, scale int) *Catalog {
+	tb.Helper()
+
+	if catalog, ok := generatedCatalogs[scale]; ok {
+		return catalog
+	}
+
+	var options GeneratorOptions
+	options.AddFlags(flag.NewFlagSet("generate", flag.ContinueOnError))
+	options.Scale = scale
+
+	fake := NewFakeSpotify(options.User)
+	artist, excluded := GenerateDiscography(options, fake)
+
+	// Only while fetching, reports read their templates from here.
+	previous, err := os.Getwd()
+	if err != nil {
+		tb.Fatalf("%v", err)
+	}
+	directory, err := ioutil.TempDir("", "beatles-generated-")
+	if err != nil {
+		tb.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(directory)
+	if err := os.Chdir(directory); err != nil {
+		tb.Fatalf("%v", err)
+	}
+	defer os.Chdir(previous)
+	if err := os.MkdirAll(CacheDirectory, 0755); err != nil {
+		tb.Fatalf("%v", err)
+	}
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	catalog, err := Fetch(context.Background(), NewSpotifyCacher(fake, false), &Config{
+		User:           options.User,
+		ArtistID:       artist.ID,
+		ArtistName:     options.ArtistName,
+		ExcludedAlbums: excluded,
+		Workers:        DefaultWorkers,
+	})
+	if err != nil {
+		tb.Fatalf("%v", err)
+	}
+
+	generatedCatalogs[scale] = catalog
+	return catalog
+}
+
+func TestGeneratedCatalog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates a catalog 10 times the size of the real one")
+	}
+
+	catalog := generatedCatalog(t, 10)
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	analysis := Exclude(Group(Build(catalog)))
+
+	// Every recording's grouped with its song, which has one original.
+	grouped := 0
+	for _, song := range analysis.Songs {
+		originals := 0
+		for _, track := range song.Tracks {
+			if track.ShortName != song.ShortName || strings.Contains(track.ShortName, " - ") {
+				t.Fatalf("expected '%s' grouped under its short name, got '%s'", track.Name, song.ShortName)
+			}
+			if track.Original {
+				originals += 1
+			}
+		}
+		if originals != 1 {
+			t.Fatalf("expected one original of '%s', got %d", song.ShortName, originals)
+		}
+		grouped += len(song.Tracks)
+	}
+	if grouped != len(analysis.Tracks) {
+		t.Fatalf("expected all %d tracks grouped, got %d", len(analysis.Tracks), grouped)
+	}
+
+	if err := Report(analysis, t.TempDir(), ReportOptions{Html: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestGenerateDuplicates(t *testing.T) {
+	var options GeneratorOptions
+	options.AddFlags(flag.NewFlagSet("generate", flag.ContinueOnError))
+	options.Duplicates = 0.5
+	options.Punctuation = 0
+
+	fake := NewFakeSpotify(options.User)
+	GenerateDiscography(options, fake)
+
+	// Every song on a studio album is new, close titles are still its own.
+	titles := make(map[string]bool)
+	near := 0
+	for _, album := range fake.Albums {
+		if !strings.HasPrefix(album.Name, "Studio Album") || strings.HasSuffix(album.Name, "(Remastered)") {
+			continue
+		}
+		for _, track := range album.Tracks.Tracks {
+			if titles[track.Name] {
+				t.Fatalf("expected a new title, '%s' was already used", track.Name)
+			}
+			titles[track.Name] = true
+		}
+	}
+	for title := range titles {
+		for earlier := range titles {
+			if title != earlier && strings.Contains(title, earlier) {
+				near += 1
+				break
+			}
+		}
+	}
+	if near == 0 {
+		t.Fatalf("expected titles close to earlier ones")
+	}
+}
+
+var benchmarkScales = []int{1, 10, 100}
+
+func BenchmarkBuild(b *testing.B) {
+	for _, scale := range benchmarkScales {
+		catalog := generatedCatalog(b, scale)
+		b.Run(fmt.Sprintf("scale-%d", scale), func(b *testing.B) {
+			log.SetOutput(ioutil.Discard)
+			defer log.SetOutput(os.Stderr)
+
+			for i := 0; i < b.N; i++ {
+				Build(catalog)
+			}
+		})
+	}
+}
+
+func BenchmarkGroup(b *testing.B) {
+	for _, scale := range benchmarkScales {
+		catalog := generatedCatalog(b, scale)
+		b.Run(fmt.Sprintf("scale-%d", scale), func(b *testing.B) {
+			log.SetOutput(ioutil.Discard)
+			defer log.SetOutput(os.Stderr)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				library := Build(catalog)
+				b.StartTimer()
+				Group(library)
+			}
+		})
+	}
+}
+
+func BenchmarkExclude(b *testing.B) {
+	for _, scale := range benchmarkScales {
+		catalog := generatedCatalog(b, scale)
+		b.Run(fmt.Sprintf("scale-%d", scale), func(b *testing.B) {
+			log.SetOutput(ioutil.Discard)
+			defer log.SetOutput(os.Stderr)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				grouping := Group(Build(catalog))
+				b.StartTimer()
+				Exclude(grouping)
+			}
+		})
+	}
+}
+
+func BenchmarkReport(b *testing.B) {
+	for _, scale := range benchmarkScales {
+		catalog := generatedCatalog(b, scale)
+		b.Run(fmt.Sprintf("scale-%d", scale), func(b *testing.B) {
+			log.SetOutput(ioutil.Discard)
+			defer log.SetOutput(os.Stderr)
+
+			analysis := Exclude(Group(Build(catalog)))
+			directory := b.TempDir()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := Report(analysis, directory, ReportOptions{Html: true}); err != nil {
+					b.Fatalf("%v", err)
+				}
+			}
+		})
+	}
+}
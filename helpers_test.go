@@ -0,0 +1,118 @@
+package beatles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+// Points the cache at a directory of the test's own, which doesn't exist
+// until something's saved.
+func useCacheDirectory(t *testing.T) string {
+	t.Helper()
+
+	previous := CacheDirectory
+	directory := filepath.Join(t.TempDir(), DefaultCacheDirectory)
+	SetCacheDirectory(directory)
+	t.Cleanup(func() {
+		SetCacheDirectory(previous)
+	})
+
+	return directory
+}
+
+// Saves v to the cache as if it had been fetched.
+func writeCacheEntry(t *testing.T, v interface{}, name string, a ...interface{}) {
+	t.Helper()
+
+	if err := os.MkdirAll(CacheDirectory, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := NewSpotifyCacher(nil, false).save(getFilePath(name, a...), v); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// Adds the bearer token the fake server insists on.
+type bearerTransport struct {
+	base http.RoundTripper
+}
+
+func (bt *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer test")
+	return bt.base.RoundTrip(authorized)
+}
+
+// Sends requests for the Spotify API to server instead.
+func fakeServerTransport(t *testing.T, server *httptest.Server) http.RoundTripper {
+	t.Helper()
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return &rewriteTransport{target: target, base: &bearerTransport{base: http.DefaultTransport}}
+}
+
+// A client of a fake server over fake, closed when the test's done.
+func fakeServerClient(t *testing.T, fake *FakeSpotify) (*spotify.Client, *FakeSpotifyServer) {
+	t.Helper()
+
+	fss := &FakeSpotifyServer{Fake: fake}
+	server := httptest.NewServer(fss)
+	t.Cleanup(server.Close)
+
+	client := spotify.NewClient(&http.Client{Transport: fakeServerTransport(t, server)})
+	return &client, fss
+}
+
+// Sends requests for the Spotify API to handler instead.
+func testSpotifyTransport(t *testing.T, handler http.Handler) http.RoundTripper {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return fakeServerTransport(t, server)
+}
+
+// A client whose requests go to handler instead of Spotify.
+func testSpotifyClient(t *testing.T, handler http.Handler) *spotify.Client {
+	t.Helper()
+
+	client := spotify.NewClient(&http.Client{Transport: testSpotifyTransport(t, handler)})
+	return &client
+}
+
+// Points the accounts service and the Web API at handler, with made up
+// credentials, until the test's done.
+func useFakeSpotify(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+
+	previousAccounts, previousTransport, previousSettings := spotifyAccountsUrl, spotifyTransport, authSettings
+	t.Cleanup(func() {
+		server.Close()
+		spotifyAccountsUrl, spotifyTransport, authSettings = previousAccounts, previousTransport, previousSettings
+	})
+
+	if err := SetSpotifyUrl(server.URL); err != nil {
+		t.Fatalf("%v", err)
+	}
+	SetAuthSettings(&AuthSettings{
+		SpotifyClientId:     "client-id",
+		SpotifyClientSecret: "client-secret",
+		SpotifyRedirectUrl:  "http://127.0.0.1:0/callback",
+		CallbackAddress:     "127.0.0.1:0",
+	})
+
+	return server
+}
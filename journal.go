@@ -0,0 +1,115 @@
+package beatles
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+const SyncJournalFile = "sync-journal.json"
+
+// How far a sync got, saved after every playlist is started and finished so
+// that an interrupted sync can be resumed. Plan identifies the playlists and
+// tracks being written, a journal is only resumed by a sync writing the same.
+type SyncJournal struct {
+	Started   time.Time                   `json:"started"`
+	Plan      string                      `json:"plan"`
+	Playlists map[string]*JournalPlaylist `json:"playlists"`
+	path      string
+}
+
+type JournalPlaylist struct {
+	ID       spotify.ID `json:"id"`
+	Started  bool       `json:"started"`
+	Complete bool       `json:"complete"`
+}
+
+// An empty path keeps the journal in memory only.
+func NewSyncJournal(path string) *SyncJournal {
+	return &SyncJournal{
+		Started:   time.Now(),
+		Playlists: make(map[string]*JournalPlaylist),
+		path:      path,
+	}
+}
+
+// Nil, without an error, when there's no journal.
+func LoadSyncJournal(path string) (*SyncJournal, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading journal: %v", err)
+	}
+
+	journal := &SyncJournal{}
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("Error parsing journal %s: %v", path, err)
+	}
+
+	if journal.Playlists == nil {
+		journal.Playlists = make(map[string]*JournalPlaylist)
+	}
+	journal.path = path
+
+	return journal, nil
+}
+
+func (j *SyncJournal) Playlist(name string) *JournalPlaylist {
+	if entry, ok := j.Playlists[name]; ok {
+		return entry
+	}
+
+	entry := &JournalPlaylist{}
+	j.Playlists[name] = entry
+	return entry
+}
+
+func (j *SyncJournal) Save() error {
+	if j.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling journal: %v", err)
+	}
+
+	if err := WriteFileAtomic(j.path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing journal: %v", err)
+	}
+
+	return nil
+}
+
+func (j *SyncJournal) Remove() error {
+	if j.path == "" {
+		return nil
+	}
+
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing journal: %v", err)
+	}
+
+	return nil
+}
+
+// Identifies the names and tracks of plans, in order.
+func PlanChecksum(plans []PlaylistPlan) string {
+	hash := sha256.New()
+	for _, plan := range plans {
+		fmt.Fprintf(hash, "%s\n", plan.Name)
+		for _, id := range plan.Tracks {
+			fmt.Fprintf(hash, "%s\n", id)
+		}
+		fmt.Fprintf(hash, "\n")
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,244 @@
+package beatles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+// Counts the writes made through it. The add numbered failAdd, counting from
+// one, fails as though the connection dropped, and onReplace is called after
+// every replace.
+type countingClient struct {
+	SpotifyClient
+	replaces  int
+	adds      int
+	failAdd   int
+	onReplace func()
+}
+
+func (cc *countingClient) ReplacePlaylistTracks(id spotify.ID, ids ...spotify.ID) error {
+	cc.replaces += 1
+	err := cc.SpotifyClient.ReplacePlaylistTracks(id, ids...)
+	if cc.onReplace != nil {
+		cc.onReplace()
+	}
+	return err
+}
+
+func (cc *countingClient) AddTracksToPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	cc.adds += 1
+	if cc.adds == cc.failAdd {
+		return "", fmt.Errorf("connection reset")
+	}
+	return cc.SpotifyClient.AddTracksToPlaylist(id, ids...)
+}
+
+// A playlist of 250 tracks, written in three batches.
+func newLongPlan(fake *FakeSpotify) PlaylistPlan {
+	artist := fake.AddArtist("The Testers")
+	album := fake.AddAlbum(artist.ID, "Long", "album", "1968-11-22", "day")
+
+	plan := PlaylistPlan{Name: "The Testers (long)", Tracks: make([]spotify.ID, 0)}
+	for i := 0; i < 250; i++ {
+		plan.Tracks = append(plan.Tracks, fake.AddTrack(album.ID, fmt.Sprintf("Song %d", i+1), 180000, 50).ID)
+	}
+	return plan
+}
+
+func loadTestJournal(t *testing.T, path string) *SyncJournal {
+	t.Helper()
+
+	journal, err := LoadSyncJournal(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if journal == nil {
+		t.Fatalf("expected a journal at %s", path)
+	}
+	return journal
+}
+
+func TestSyncPlaylistResume(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		failAdd int
+		written int
+		adds    int
+	}{
+		{"interrupted after the first replace batch", 1, 100, 2},
+		{"interrupted after a partial append", 2, 200, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake := NewFakeSpotify("tester")
+			plan := newLongPlan(fake)
+			path := filepath.Join(t.TempDir(), SyncJournalFile)
+
+			interrupted := &countingClient{SpotifyClient: fake, failAdd: test.failAdd}
+			if err := syncPlaylist(interrupted, "tester", plan, NewSyncJournal(path)); err == nil {
+				t.Fatalf("expected the sync to fail")
+			}
+
+			playlist := fake.GetPlaylistByName("tester", plan.Name)
+			if len(playlist.TrackIDs) != test.written || !isPrefix(playlist.TrackIDs, plan.Tracks) {
+				t.Fatalf("expected the first %d tracks written, got %d", test.written, len(playlist.TrackIDs))
+			}
+
+			journal := loadTestJournal(t, path)
+			entry := journal.Playlist(plan.Name)
+			if !entry.Started || entry.Complete || entry.ID != playlist.ID {
+				t.Fatalf("expected the journal to have %s started, got %+v", playlist.ID, entry)
+			}
+
+			resumed := &countingClient{SpotifyClient: fake}
+			if err := syncPlaylist(resumed, "tester", plan, journal); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if resumed.replaces != 0 || resumed.adds != test.adds {
+				t.Fatalf("expected only the rest added, in %d adds, got %d replaces and %d adds", test.adds, resumed.replaces, resumed.adds)
+			}
+			if !isPrefix(playlist.TrackIDs, plan.Tracks) || len(playlist.TrackIDs) != len(plan.Tracks) {
+				t.Fatalf("expected all %d tracks, got %d", len(plan.Tracks), len(playlist.TrackIDs))
+			}
+			if !loadTestJournal(t, path).Playlist(plan.Name).Complete {
+				t.Fatalf("expected the journal to have the playlist complete")
+			}
+
+			// Once complete it's left alone.
+			again := &countingClient{SpotifyClient: fake}
+			if err := syncPlaylist(again, "tester", plan, journal); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if again.replaces != 0 || again.adds != 0 {
+				t.Fatalf("expected no writes to a complete playlist, got %d replaces and %d adds", again.replaces, again.adds)
+			}
+		})
+	}
+}
+
+func TestSyncPlaylistResumeChanged(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	plan := newLongPlan(fake)
+	journal := NewSyncJournal("")
+
+	if err := syncPlaylist(&countingClient{SpotifyClient: fake, failAdd: 2}, "tester", plan, journal); err == nil {
+		t.Fatalf("expected the sync to fail")
+	}
+
+	// Edited in between, what's there is no longer a prefix of the plan.
+	playlist := fake.GetPlaylistByName("tester", plan.Name)
+	snapshot := playlist.SnapshotID
+	if _, err := fake.RemoveTracksFromPlaylist(playlist.ID, plan.Tracks[0]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if playlist.SnapshotID == snapshot {
+		t.Fatalf("expected the edit to change the snapshot")
+	}
+
+	resumed := &countingClient{SpotifyClient: fake}
+	if err := syncPlaylist(resumed, "tester", plan, journal); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if resumed.replaces != 1 || resumed.adds != 2 {
+		t.Fatalf("expected the playlist to be written from the start, got %d replaces and %d adds", resumed.replaces, resumed.adds)
+	}
+	if !isPrefix(playlist.TrackIDs, plan.Tracks) || len(playlist.TrackIDs) != len(plan.Tracks) {
+		t.Fatalf("expected all %d tracks, got %d", len(plan.Tracks), len(playlist.TrackIDs))
+	}
+}
+
+func TestSyncPlaylistResumeRecreated(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	plan := newLongPlan(fake)
+	journal := NewSyncJournal("")
+
+	if err := syncPlaylist(&countingClient{SpotifyClient: fake, failAdd: 1}, "tester", plan, journal); err == nil {
+		t.Fatalf("expected the sync to fail")
+	}
+
+	// Deleted and made again, the journal's ID is for a playlist that's gone.
+	fake.Playlists = fake.Playlists[:0]
+	fake.AddPlaylist("tester", plan.Name, plan.Tracks[:100]...)
+
+	resumed := &countingClient{SpotifyClient: fake}
+	if err := syncPlaylist(resumed, "tester", plan, journal); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if resumed.replaces != 1 {
+		t.Fatalf("expected a different playlist to be written from the start, got %d replaces", resumed.replaces)
+	}
+	if playlist := fake.GetPlaylistByName("tester", plan.Name); len(playlist.TrackIDs) != len(plan.Tracks) {
+		t.Fatalf("expected all %d tracks, got %d", len(plan.Tracks), len(playlist.TrackIDs))
+	}
+}
+
+func TestSyncResumeInterrupted(t *testing.T) {
+	tc := newTestCatalog()
+	analysis := tc.analyze(t)
+	path := filepath.Join(t.TempDir(), SyncJournalFile)
+
+	// Interrupted while the first playlist is being written, which is
+	// finished before stopping.
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &countingClient{SpotifyClient: tc.fake, onReplace: cancel}
+	if err := Sync(ctx, client, analysis, SyncOptions{Journal: NewSyncJournal(path)}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if client.replaces != 1 || len(tc.fake.Playlists) != 2 {
+		t.Fatalf("expected one playlist written, got %d replaces and %d playlists", client.replaces, len(tc.fake.Playlists))
+	}
+	if !analysis.Catalog.Summary.Failed("sync") {
+		t.Fatalf("expected the rest to fail as interrupted")
+	}
+
+	journal := loadTestJournal(t, path)
+	resumed := &countingClient{SpotifyClient: tc.fake}
+	analysis = tc.analyze(t)
+	if err := Sync(context.Background(), resumed, analysis, SyncOptions{Journal: journal}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if resumed.replaces != 5 {
+		t.Fatalf("expected the 5 remaining playlists written, got %d", resumed.replaces)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the journal to be removed once complete, %v", err)
+	}
+}
+
+func TestSyncJournalPlanChanged(t *testing.T) {
+	tc := newTestCatalog()
+	analysis := tc.analyze(t)
+
+	journal := NewSyncJournal(filepath.Join(t.TempDir(), SyncJournalFile))
+	journal.Plan = PlanChecksum([]PlaylistPlan{{Name: "The Testers (R >= 3)", Tracks: tc.ids("Help")}})
+
+	if err := Sync(context.Background(), tc.fake, analysis, SyncOptions{Journal: journal}); err == nil {
+		t.Fatalf("expected a journal for different playlists not to be resumed")
+	}
+	if len(tc.fake.Playlists) != 1 {
+		t.Fatalf("expected nothing written, got %d playlists", len(tc.fake.Playlists))
+	}
+}
+
+func TestIsPrefix(t *testing.T) {
+	ids := []spotify.ID{"a", "b", "c"}
+	for _, test := range []struct {
+		prefix   []spotify.ID
+		expected bool
+	}{
+		{[]spotify.ID{}, true},
+		{[]spotify.ID{"a"}, true},
+		{[]spotify.ID{"a", "b", "c"}, true},
+		{[]spotify.ID{"b"}, false},
+		{[]spotify.ID{"a", "c"}, false},
+		{[]spotify.ID{"a", "b", "c", "d"}, false},
+	} {
+		if isPrefix(test.prefix, ids) != test.expected {
+			t.Errorf("isPrefix(%v, %v) should be %v", test.prefix, ids, test.expected)
+		}
+	}
+}
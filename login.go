@@ -0,0 +1,258 @@
+package beatles
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const DefaultLoginTimeout = 5 * time.Minute
+
+// One attempt at the authorization code flow. The state and PKCE verifier
+// are random and only good for this login, so a callback from another login,
+// or from someone else entirely, is refused.
+type SpotifyLogin struct {
+	settings *AuthSettings
+	config   *oauth2.Config
+	state    string
+	verifier string
+}
+
+func randomString(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("Error generating random: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func NewSpotifyLogin(settings *AuthSettings) (*SpotifyLogin, error) {
+	state, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := randomString(48)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpotifyLogin{
+		settings: settings,
+		config:   spotifyOauthConfig(settings),
+		state:    state,
+		verifier: verifier,
+	}, nil
+}
+
+// The page to send the user to.
+func (sl *SpotifyLogin) AuthCodeURL() string {
+	challenge := sha256.Sum256([]byte(sl.verifier))
+	return sl.config.AuthCodeURL(sl.state,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// The query Spotify sent the browser back with, for a state that isn't this
+// login's.
+type StateMismatchError struct {
+	State string
+}
+
+func (e *StateMismatchError) Error() string {
+	return fmt.Sprintf("Login state mismatch (%s)", e.State)
+}
+
+// Exchanges the code in the query Spotify redirected back with.
+func (sl *SpotifyLogin) Exchange(ctx context.Context, query url.Values) (*oauth2.Token, error) {
+	if state := query.Get("state"); state != sl.state {
+		return nil, &StateMismatchError{State: state}
+	}
+
+	if reason := query.Get("error"); reason != "" {
+		return nil, fmt.Errorf("Login refused: %s", reason)
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return nil, fmt.Errorf("Login returned without a code")
+	}
+
+	token, err := sl.config.Exchange(spotifyContext(ctx), code, oauth2.SetAuthURLParam("code_verifier", sl.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("Error getting token: %v", err)
+	}
+
+	return token, nil
+}
+
+type loginResult struct {
+	token *oauth2.Token
+	err   error
+}
+
+type LoginOptions struct {
+	Timeout time.Duration
+	// Redirect URLs pasted by the user, read a line at a time alongside the
+	// callback server, for when the browser can't reach it.
+	Pasted io.Reader
+}
+
+// Serves the redirect URL's path on the callback address until a login
+// comes back, ctx is done or the timeout passes, and then shuts down. visit
+// is given the page to send the user to once the server's listening. Strays,
+// callbacks with the wrong state, get an error page and the login keeps
+// waiting. With options.Pasted the first of a callback or a pasted URL wins,
+// and failing to listen isn't fatal.
+func (sl *SpotifyLogin) ServeCallback(ctx context.Context, options LoginOptions, visit func(authUrl string)) (*oauth2.Token, error) {
+	redirect, err := url.Parse(sl.settings.SpotifyRedirectUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid redirect URL '%s': %v", sl.settings.SpotifyRedirectUrl, err)
+	}
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLoginTimeout
+	}
+
+	results := make(chan loginResult, 1)
+
+	// Stops reading pastes once the login's over, whichever way it ended.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if options.Pasted != nil {
+		go sl.readPasted(ctx, options.Pasted, results)
+	}
+
+	listener, err := net.Listen("tcp", sl.settings.CallbackAddress)
+	if err != nil && options.Pasted == nil {
+		return nil, fmt.Errorf("Error listening for the login callback: %v", err)
+	}
+	if err != nil {
+		log.Printf("Error listening for the login callback, waiting for a pasted URL only: %v", err)
+		visit(sl.AuthCodeURL())
+		return waitForLogin(ctx, timeout, results)
+	}
+
+	// Redirects to the bare host come back to its root.
+	path := redirect.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		token, err := sl.Exchange(r.Context(), r.URL.Query())
+		if mismatch, ok := err.(*StateMismatchError); ok {
+			log.Printf("Ignoring callback: %v", mismatch)
+			writeLoginPage(w, http.StatusBadRequest, "This isn't the login that's waiting, start again from the link beatles printed.")
+			return
+		}
+		if err != nil {
+			writeLoginPage(w, http.StatusForbidden, fmt.Sprintf("Login failed: %v", err))
+		} else {
+			writeLoginPage(w, http.StatusOK, "Logged in, you can close this window.")
+		}
+
+		select {
+		case results <- loginResult{token, err}:
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	defer func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("Waiting for the login to return to %s, listening on %s", sl.settings.SpotifyRedirectUrl, listener.Addr())
+
+	visit(sl.AuthCodeURL())
+
+	return waitForLogin(ctx, timeout, results)
+}
+
+func waitForLogin(ctx context.Context, timeout time.Duration, results chan loginResult) (*oauth2.Token, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-results:
+		return result.token, result.err
+	case <-timer.C:
+		return nil, fmt.Errorf("Timed out waiting for the login after %v", timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Anything that isn't this login's redirect URL is complained about and the
+// next line read, so a mangled paste can be tried again. Returns once a result
+// is sent or ctx is done, though a read that's already waiting can't be
+// interrupted: with the callback winning, it waits for one more line, or
+// EOF, and then returns without using it.
+func (sl *SpotifyLogin) readPasted(ctx context.Context, pasted io.Reader, results chan loginResult) {
+	scanner := bufio.NewScanner(pasted)
+	for ctx.Err() == nil && scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		redirected, err := url.Parse(line)
+		if err != nil || redirected.Query().Get("state") == "" {
+			log.Printf("That isn't the address the browser was sent to, paste all of it")
+			continue
+		}
+
+		token, err := sl.Exchange(ctx, redirected.Query())
+		if _, ok := err.(*StateMismatchError); ok {
+			log.Printf("That address is from another login, paste the one from this login")
+			continue
+		}
+
+		select {
+		case results <- loginResult{token, err}:
+		case <-ctx.Done():
+		}
+		return
+	}
+}
+
+func writeLoginPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>beatles</title></head><body><p>%s</p></body></html>\n", html.EscapeString(message))
+}
+
+// Prints the page to log in on, for the user to open.
+func printLoginUrl(authUrl string) {
+	log.Println("Please log in to Spotify by visiting the following page in your browser:", authUrl)
+}
+
+func printPasteLoginUrl(authUrl string) {
+	log.Println("Please log in to Spotify by visiting the following page in a browser:", authUrl)
+	log.Println("If the browser can't reach this machine, paste the address it ends up on here and press enter.")
+}
@@ -0,0 +1,297 @@
+package beatles
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Counts the tokens asked for.
+type tokenCounter struct {
+	handler http.Handler
+	tokens  int32
+}
+
+func (tc *tokenCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/token" {
+		atomic.AddInt32(&tc.tokens, 1)
+	}
+	tc.handler.ServeHTTP(w, r)
+}
+
+func (tc *tokenCounter) count() int {
+	return int(atomic.LoadInt32(&tc.tokens))
+}
+
+// A login whose callback listens on a port of its own, against a fake
+// accounts service.
+func newTestLogin(t *testing.T) (*SpotifyLogin, *tokenCounter) {
+	t.Helper()
+
+	counter := &tokenCounter{handler: &FakeSpotifyServer{Fake: NewFakeSpotify("tester")}}
+	useFakeSpotify(t, counter)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	settings, err := CurrentAuthSettings()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	settings.CallbackAddress = address
+	settings.SpotifyRedirectUrl = fmt.Sprintf("http://%s/callback", address)
+
+	login, err := NewSpotifyLogin(settings)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return login, counter
+}
+
+// Opens a page as the browser would, following the fake's redirect back to
+// the callback. Returns the status and page the browser ends up with.
+func browse(page string) (int, string, error) {
+	res, err := http.Get(page)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(body), err
+}
+
+type browsed struct {
+	status int
+	page   string
+	err    error
+}
+
+// Browses to the login page in the background, as the user would.
+func browseLater(pages chan browsed) func(authUrl string) {
+	return func(authUrl string) {
+		go func() {
+			status, page, err := browse(authUrl)
+			pages <- browsed{status, page, err}
+		}()
+	}
+}
+
+func requireShutDown(t *testing.T, login *SpotifyLogin) {
+	t.Helper()
+
+	if _, _, err := browse(login.settings.SpotifyRedirectUrl); err == nil {
+		t.Fatalf("expected the callback server to be shut down")
+	}
+}
+
+func TestServeCallback(t *testing.T) {
+	login, _ := newTestLogin(t)
+
+	pages := make(chan browsed, 1)
+	token, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 10 * time.Second}, browseLater(pages))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if token.AccessToken != FakeAccessToken || token.RefreshToken == "" {
+		t.Fatalf("expected the fake's tokens, got %+v", token)
+	}
+
+	result := <-pages
+	if result.err != nil || result.status != http.StatusOK || !strings.Contains(result.page, "Logged in") {
+		t.Fatalf("expected the browser to be told it's logged in, got %d %s %v", result.status, result.page, result.err)
+	}
+
+	requireShutDown(t, login)
+}
+
+func TestServeCallbackNoPath(t *testing.T) {
+	login, _ := newTestLogin(t)
+
+	settings := *login.settings
+	settings.SpotifyRedirectUrl = strings.TrimSuffix(settings.SpotifyRedirectUrl, "/callback")
+	login, err := NewSpotifyLogin(&settings)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pages := make(chan browsed, 1)
+	token, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 10 * time.Second}, browseLater(pages))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if token.AccessToken != FakeAccessToken {
+		t.Fatalf("expected the fake's tokens, got %+v", token)
+	}
+
+	result := <-pages
+	if result.err != nil || result.status != http.StatusOK {
+		t.Fatalf("expected the browser to be told it's logged in, got %d %s %v", result.status, result.page, result.err)
+	}
+}
+
+func TestServeCallbackWrongVerifier(t *testing.T) {
+	login, _ := newTestLogin(t)
+
+	// The code's challenge was made from the verifier the login started
+	// with, exchanging it with any other is refused.
+	pages := make(chan browsed, 1)
+	visit := func(authUrl string) {
+		login.verifier = "another-verifier"
+		browseLater(pages)(authUrl)
+	}
+
+	_, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 10 * time.Second}, visit)
+	if err == nil {
+		t.Fatalf("expected the exchange to fail with the wrong verifier")
+	}
+
+	result := <-pages
+	if result.status != http.StatusForbidden || !strings.Contains(result.page, "Login failed") {
+		t.Fatalf("expected the browser to be told the login failed, got %d %s", result.status, result.page)
+	}
+
+	requireShutDown(t, login)
+}
+
+func TestServeCallbackStateMismatch(t *testing.T) {
+	login, _ := newTestLogin(t)
+
+	// A stray callback first, from some other login. It's refused and the
+	// login keeps waiting for its own.
+	pages := make(chan browsed, 1)
+	strays := make(chan browsed, 1)
+	visit := func(authUrl string) {
+		go func() {
+			status, page, err := browse(login.settings.SpotifyRedirectUrl + "?code=stolen&state=another-state")
+			strays <- browsed{status, page, err}
+			browseLater(pages)(authUrl)
+		}()
+	}
+
+	token, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 10 * time.Second}, visit)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if token.AccessToken != FakeAccessToken {
+		t.Fatalf("expected the fake's token, got %+v", token)
+	}
+
+	stray := <-strays
+	if stray.status != http.StatusBadRequest || !strings.Contains(stray.page, "the login that") {
+		t.Fatalf("expected the stray callback to be refused, got %d %s", stray.status, stray.page)
+	}
+	if result := <-pages; result.status != http.StatusOK {
+		t.Fatalf("expected the login's own callback to succeed, got %d %s", result.status, result.page)
+	}
+
+	requireShutDown(t, login)
+}
+
+func TestServeCallbackTimeout(t *testing.T) {
+	login, _ := newTestLogin(t)
+
+	started := time.Now()
+	_, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 100 * time.Millisecond}, func(authUrl string) {})
+	if err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Fatalf("expected the login to time out, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected the login to give up after its timeout, took %v", elapsed)
+	}
+
+	requireShutDown(t, login)
+}
+
+// Where the fake sends the browser, without going there. Called from the
+// login's goroutines, so failing doesn't stop the test.
+func redirectedTo(t *testing.T, authUrl string) string {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authUrl)
+	if err != nil {
+		t.Errorf("%v", err)
+		return ""
+	}
+	res.Body.Close()
+
+	return res.Header.Get("Location")
+}
+
+func TestServeCallbackPasted(t *testing.T) {
+	login, _ := newTestLogin(t)
+
+	// Mangled, from another login, and then the right one.
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	visit := func(authUrl string) {
+		go func() {
+			redirect := redirectedTo(t, authUrl)
+			fmt.Fprintf(writer, "not a url\n")
+			fmt.Fprintf(writer, "%s\n", strings.Replace(redirect, "state=", "state=another", 1))
+			fmt.Fprintf(writer, "%s\n", redirect)
+		}()
+	}
+
+	token, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 10 * time.Second, Pasted: reader}, visit)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if token.AccessToken != FakeAccessToken {
+		t.Fatalf("expected the fake's token, got %+v", token)
+	}
+
+	requireShutDown(t, login)
+}
+
+func TestServeCallbackPastedAfterCallback(t *testing.T) {
+	login, counter := newTestLogin(t)
+
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	pages := make(chan browsed, 1)
+	redirects := make(chan string, 1)
+	visit := func(authUrl string) {
+		redirects <- redirectedTo(t, authUrl)
+		browseLater(pages)(authUrl)
+	}
+
+	if _, err := login.ServeCallback(context.Background(), LoginOptions{Timeout: 10 * time.Second, Pasted: reader}, visit); err != nil {
+		t.Fatalf("%v", err)
+	}
+	<-pages
+
+	// The read that was waiting gets this line, which is dropped rather than
+	// exchanged, and nothing more's read.
+	fmt.Fprintf(writer, "%s\n", <-redirects)
+	time.Sleep(100 * time.Millisecond)
+	if counter.count() != 1 {
+		t.Fatalf("expected only the callback's code exchanged, got %d token requests", counter.count())
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := fmt.Fprintf(writer, "another line\n")
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatalf("expected nothing more to be read once the login's over")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
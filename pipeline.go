@@ -0,0 +1,711 @@
+package beatles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// The pipeline runs Fetch, Build, Group, Exclude, Report and Sync in that
+// order, each stage taking the state returned by the one before it.
+type Config struct {
+	User           string
+	ArtistID       spotify.ID
+	ArtistName     string
+	ExcludedAlbums []spotify.ID
+	Workers        int
+	// The client is only authorized as the app, exclusion playlists are read
+	// from the cache rather than fetched.
+	CatalogOnly bool
+}
+
+const DefaultWorkers = 4
+
+type CatalogAlbum struct {
+	Album  spotify.SimpleAlbum
+	Tracks []spotify.FullTrack
+}
+
+type ExcludedAlbum struct {
+	Album    spotify.SimpleAlbum
+	TrackIDs []spotify.ID
+}
+
+type ExclusionPlaylist struct {
+	Playlist Playlist
+	Tracks   []spotify.PlaylistTrack
+}
+
+// Everything the analysis needs from Spotify, nothing after this touches the
+// network until Sync.
+type Catalog struct {
+	Config             *Config
+	Artist             *spotify.FullArtist
+	Albums             []*CatalogAlbum
+	ExcludedAlbums     []*ExcludedAlbum
+	ExclusionPlaylists []*ExclusionPlaylist
+	Summary            *Summary
+}
+
+type Library struct {
+	Catalog *Catalog
+	Audit   *AuditLog
+	Tracks  []*TrackInfo
+}
+
+// Recordings of the same song, grouped by ShortName.
+type Song struct {
+	ShortName   string
+	ReleaseDate time.Time
+	Tracks      []*TrackInfo
+}
+
+type Grouping struct {
+	*Library
+	Songs []*Song
+}
+
+type Analysis struct {
+	*Grouping
+	ByReleaseDate []*TrackInfo
+}
+
+// Fetches with up to config.Workers requests in flight. Results are put back
+// in discography order, so the catalog doesn't depend on scheduling. Once ctx
+// is done requests in flight finish and nothing more is started.
+func Fetch(ctx context.Context, cacher *SpotifyCacher, config *Config) (*Catalog, error) {
+	catalog := &Catalog{
+		Config:             config,
+		Albums:             make([]*CatalogAlbum, 0),
+		ExcludedAlbums:     make([]*ExcludedAlbum, 0),
+		ExclusionPlaylists: make([]*ExclusionPlaylist, 0),
+		Summary:            NewSummary(),
+	}
+
+	artist, err := cacher.GetArtist(config.ArtistID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting artist: %v", err)
+	}
+
+	log.Printf("Artist: %v", artist.Name)
+
+	catalog.Artist = artist
+
+	albums, err := cacher.GetArtistAlbums(artist.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting albums: %v", err)
+	}
+
+	albumIds := make([]spotify.ID, 0)
+	for _, album := range albums {
+		albumIds = append(albumIds, album.ID)
+	}
+	albumIds = append(albumIds, config.ExcludedAlbums...)
+
+	// Failures here are only logged, the albums are fetched one by one below
+	// and fail there if they have to.
+	err = parallel(ctx, config.Workers, (len(albumIds)+19)/20, func(i int) {
+		batch := albumIds[i*20 : min(i*20+20, len(albumIds))]
+		if err := cacher.PrefetchAlbums(batch); err != nil {
+			log.Printf("Error prefetching albums: %v", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	albumTracks := make([][]spotify.SimpleTrack, len(albums))
+	albumErrors := make([]error, len(albums))
+	err = parallel(ctx, config.Workers, len(albums), func(i int) {
+		albumTracks[i], albumErrors[i] = cacher.GetAlbumTracks(albums[i].ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every track once, batched across albums to fill each request.
+	trackIds := make([]spotify.ID, 0)
+	seen := make(map[spotify.ID]bool)
+	for i := range albums {
+		for _, track := range albumTracks[i] {
+			if !seen[track.ID] {
+				seen[track.ID] = true
+				trackIds = append(trackIds, track.ID)
+			}
+		}
+	}
+
+	batches := (len(trackIds) + 49) / 50
+	batchTracks := make([][]spotify.FullTrack, batches)
+	batchErrors := make([]error, batches)
+	err = parallel(ctx, config.Workers, batches, func(i int) {
+		batch := trackIds[i*50 : min(i*50+50, len(trackIds))]
+		batchTracks[i], batchErrors[i] = cacher.GetTracks(batch)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fullTracks := make(map[spotify.ID]spotify.FullTrack)
+	trackErrors := make(map[spotify.ID]error)
+	for i := 0; i < batches; i++ {
+		for _, id := range trackIds[i*50 : min(i*50+50, len(trackIds))] {
+			if batchErrors[i] != nil {
+				trackErrors[id] = batchErrors[i]
+			}
+		}
+		for _, track := range batchTracks[i] {
+			fullTracks[track.ID] = track
+		}
+	}
+
+	for i, album := range albums {
+		unit := fmt.Sprintf("album %v (%v)", album.Name, album.ID)
+		catalogAlbum, err := newCatalogAlbum(album, albumTracks[i], albumErrors[i], fullTracks, trackErrors)
+		if err != nil {
+			catalog.Summary.Fail("fetch", unit, err)
+			continue
+		}
+
+		catalog.Albums = append(catalog.Albums, catalogAlbum)
+		catalog.Summary.Succeed("fetch", unit)
+	}
+
+	excludedAlbums := make([]*ExcludedAlbum, len(config.ExcludedAlbums))
+	excludedErrors := make([]error, len(config.ExcludedAlbums))
+	err = parallel(ctx, config.Workers, len(config.ExcludedAlbums), func(i int) {
+		excludedAlbums[i], excludedErrors[i] = fetchExcludedAlbum(cacher, config.ExcludedAlbums[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, albumId := range config.ExcludedAlbums {
+		unit := fmt.Sprintf("excluded album %v", albumId)
+		if excludedErrors[i] != nil {
+			catalog.Summary.Fail("fetch", unit, excludedErrors[i])
+			continue
+		}
+
+		log.Printf("ExcludedAlbum: %v (%v) (%v tracks)", excludedAlbums[i].Album.Name, excludedAlbums[i].Album.ReleaseDate, len(excludedAlbums[i].TrackIDs))
+
+		catalog.ExcludedAlbums = append(catalog.ExcludedAlbums, excludedAlbums[i])
+		catalog.Summary.Succeed("fetch", unit)
+	}
+
+	// Playlists need user authorization, without it they come from an earlier
+	// fetch that had it.
+	playlistCacher := cacher
+	if config.CatalogOnly && !cacher.offline {
+		log.Printf("Catalog only, reading exclusion playlists from %s", CacheDirectory)
+		playlistCacher = NewSpotifyCacher(nil, true)
+	}
+
+	playlists, err := playlistCacher.GetPlaylists(config.User)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting playlists: %v", err)
+	}
+
+	exclusions := make([]Playlist, 0)
+	for _, playlist := range playlists.Playlists {
+		if strings.HasPrefix(playlist.Name, config.ArtistName) && strings.Contains(playlist.Name, "(excluded") {
+			exclusions = append(exclusions, playlist)
+		}
+	}
+
+	exclusionTracks := make([][]spotify.PlaylistTrack, len(exclusions))
+	exclusionErrors := make([]error, len(exclusions))
+	err = parallel(ctx, config.Workers, len(exclusions), func(i int) {
+		exclusionTracks[i], exclusionErrors[i] = playlistCacher.GetPlaylistTracks(exclusions[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, playlist := range exclusions {
+		unit := fmt.Sprintf("exclusion playlist '%s'", playlist.Name)
+		if exclusionErrors[i] != nil {
+			catalog.Summary.Fail("fetch", unit, fmt.Errorf("Error getting tracks: %v", exclusionErrors[i]))
+			continue
+		}
+
+		log.Printf("Applying exclusion playlist '%s' (%v tracks)", playlist.Name, len(exclusionTracks[i]))
+
+		catalog.ExclusionPlaylists = append(catalog.ExclusionPlaylists, &ExclusionPlaylist{
+			Playlist: playlist,
+			Tracks:   exclusionTracks[i],
+		})
+		catalog.Summary.Succeed("fetch", unit)
+	}
+
+	if playlistCacher != cacher {
+		if missing := playlistCacher.Missing(); len(missing) > 0 {
+			catalog.Summary.Fail("fetch", "exclusion playlists", fmt.Errorf("Missing %d cache entries, fetch with user authorization first: %s", len(missing), strings.Join(missing, ", ")))
+		} else if info, err := os.Stat(getFilePath("playlists-%s.json", config.User)); err == nil {
+			catalog.Summary.Note("fetch", "exclusion playlists", fmt.Sprintf("Read from the cache as of %s, app-only authorization can't read playlists", info.ModTime().Format("2006-01-02 15:04")))
+		}
+	}
+
+	if missing := cacher.Missing(); len(missing) > 0 {
+		return nil, fmt.Errorf("Offline and missing %d cache entries:\n  %s", len(missing), strings.Join(missing, "\n  "))
+	}
+
+	return catalog, nil
+}
+
+func newCatalogAlbum(album spotify.SimpleAlbum, tracks []spotify.SimpleTrack, err error, fullTracks map[spotify.ID]spotify.FullTrack, trackErrors map[spotify.ID]error) (*CatalogAlbum, error) {
+	if err != nil {
+		return nil, fmt.Errorf("Error getting album tracks: %v", err)
+	}
+
+	log.Printf("Album: %v (%v) (%v tracks) (%v)", album.Name, album.ReleaseDate, len(tracks), album.ID)
+
+	catalogAlbum := &CatalogAlbum{
+		Album:  album,
+		Tracks: make([]spotify.FullTrack, 0),
+	}
+
+	for _, track := range tracks {
+		if err, ok := trackErrors[track.ID]; ok {
+			return nil, fmt.Errorf("Error getting full tracks: %v", err)
+		}
+		if fullTrack, ok := fullTracks[track.ID]; ok {
+			catalogAlbum.Tracks = append(catalogAlbum.Tracks, fullTrack)
+		}
+	}
+
+	return catalogAlbum, nil
+}
+
+func fetchExcludedAlbum(cacher *SpotifyCacher, albumId spotify.ID) (*ExcludedAlbum, error) {
+	album, err := cacher.GetAlbum(albumId)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting album: %v", err)
+	}
+
+	tracks, err := cacher.GetAlbumTracks(album.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting album tracks: %v", err)
+	}
+
+	return &ExcludedAlbum{
+		Album:    album.SimpleAlbum,
+		TrackIDs: GetTrackIdsFromSimpleTracks(tracks),
+	}, nil
+}
+
+// Calls fn with every index below n, on up to workers goroutines at a time,
+// until ctx is done.
+func parallel(ctx context.Context, workers, n int, fn func(i int)) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// Tracks appearing on several albums are kept once, under the first album
+// they were seen on.
+func Build(catalog *Catalog) *Library {
+	library := &Library{
+		Catalog: catalog,
+		Audit:   NewAuditLog(),
+		Tracks:  make([]*TrackInfo, 0),
+	}
+
+	seen := make(map[spotify.ID]bool)
+	for _, album := range catalog.Albums {
+		for _, track := range album.Tracks {
+			if seen[track.ID] {
+				continue
+			}
+			seen[track.ID] = true
+
+			trackInfo, err := NewTrackInfo(album.Album.Name, album.Album.ReleaseDate, track)
+			if err != nil {
+				catalog.Summary.Fail("build", fmt.Sprintf("track %v (%v)", track.Name, track.ID), err)
+				continue
+			}
+
+			library.Tracks = append(library.Tracks, trackInfo)
+		}
+	}
+
+	sort.Sort(ByName(library.Tracks))
+
+	log.Printf("Got %v full tracks", len(library.Tracks))
+
+	return library
+}
+
+// A song is released with its earliest recording, which is the original.
+func Group(library *Library) *Grouping {
+	byShortNames := make(map[string]*Song)
+	songs := make([]*Song, 0)
+	for _, track := range library.Tracks {
+		song, ok := byShortNames[track.ShortName]
+		if !ok {
+			song = &Song{
+				ShortName: track.ShortName,
+				Tracks:    make([]*TrackInfo, 0),
+			}
+			byShortNames[track.ShortName] = song
+			songs = append(songs, song)
+		}
+		song.Tracks = append(song.Tracks, track)
+	}
+
+	for _, song := range songs {
+		song.ReleaseDate = song.Tracks[0].AlbumReleaseDate
+		for _, track := range song.Tracks {
+			if track.AlbumReleaseDate.Before(song.ReleaseDate) {
+				song.ReleaseDate = track.AlbumReleaseDate
+			}
+		}
+
+		for _, track := range song.Tracks {
+			track.Recordings = len(song.Tracks)
+			track.SongReleaseDate = song.ReleaseDate
+			track.Has1Recording = len(song.Tracks) == 1
+			track.Has3OrMoreRecordings = len(song.Tracks) >= 3
+		}
+
+		for _, track := range song.Tracks {
+			if track.AlbumReleaseDate == song.ReleaseDate {
+				library.Audit.Append(track.Name, fmt.Sprintf("Marked as original (%v)", track.Album))
+				track.Original = true
+				break
+			}
+		}
+	}
+
+	return &Grouping{
+		Library: library,
+		Songs:   songs,
+	}
+}
+
+func Exclude(grouping *Grouping) *Analysis {
+	catalog := grouping.Catalog
+	al := grouping.Audit
+
+	excludedTracks := make(map[spotify.ID]string)
+	guessedTracks := make(map[spotify.ID]string)
+	for _, playlist := range catalog.ExclusionPlaylists {
+		for _, track := range playlist.Tracks {
+			excludedTracks[track.Track.ID] = playlist.Playlist.Name
+			if strings.Contains(playlist.Playlist.Name, "guessed") {
+				guessedTracks[track.Track.ID] = playlist.Playlist.Name
+			}
+		}
+	}
+
+	tracksOnExcludedAlbums := make(map[spotify.ID]string)
+	for _, album := range catalog.ExcludedAlbums {
+		for _, id := range album.TrackIDs {
+			tracksOnExcludedAlbums[id] = album.Album.Name
+		}
+	}
+
+	log.Printf("Have %d excluded tracks", len(excludedTracks))
+	log.Printf("Have %d tracks from excluded albums", len(tracksOnExcludedAlbums))
+
+	for _, track := range grouping.Tracks {
+		if reason, ok := excludedTracks[track.ID]; ok {
+			reason := fmt.Sprintf("Excluded by %s", reason)
+			track.Exclude(reason)
+			al.Append(track.Name, reason)
+		}
+
+		if _, ok := guessedTracks[track.ID]; ok {
+			track.Guessed = true
+		}
+
+		if track.Duration < 60*1000 {
+			reason := fmt.Sprintf("Too short (%vs)", track.Duration/1000.0)
+			track.Exclude(reason)
+			al.Append(track.Name, reason)
+		}
+	}
+
+	for _, song := range grouping.Songs {
+		for _, track := range song.Tracks {
+			if albumName, ok := tracksOnExcludedAlbums[track.ID]; ok {
+				for _, track := range song.Tracks {
+					track.OnExcludedAlbum = true
+					reason := fmt.Sprintf("Excluded album (%v)", albumName)
+					track.Exclude(reason)
+					al.Append(track.Name, reason)
+				}
+			}
+		}
+
+		if len(song.Tracks) < 3 {
+			for _, track := range song.Tracks {
+				reason := fmt.Sprintf("Too few recordings (%v)", len(song.Tracks))
+				track.Exclude(reason)
+				al.Append(track.Name, reason)
+			}
+		}
+	}
+
+	// The playlist has always been sorted before songs' release dates are
+	// known, which leaves it in the library's order by name.
+	byReleaseDate := make([]*TrackInfo, len(grouping.Tracks))
+	copy(byReleaseDate, grouping.Tracks)
+
+	return &Analysis{
+		Grouping:      grouping,
+		ByReleaseDate: byReleaseDate,
+	}
+}
+
+// Writes the org tables and the audit log into directory, recording each
+// file in the summary. With options.Html the same as pages, with the
+// stylesheet and script they share.
+func Report(analysis *Analysis, directory string, options ReportOptions) error {
+	summary := analysis.Catalog.Summary
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return fmt.Errorf("Error creating %s: %v", directory, err)
+	}
+
+	data := NewReportData(analysis)
+
+	GenerateTable(data, directory, summary, options)
+
+	path := filepath.Join(directory, "audit.org")
+	if err := analysis.Audit.Write(path); err != nil {
+		summary.Fail("report", path, fmt.Errorf("Error writing audit log: %v", err))
+	} else {
+		summary.Succeed("report", path)
+	}
+
+	if options.Html {
+		path := filepath.Join(directory, "audit.html")
+		if err := renderHtmlTemplate("audit.html.template", path, data); err != nil {
+			summary.Fail("report", path, err)
+		} else {
+			summary.Succeed("report", path)
+		}
+	}
+
+	return nil
+}
+
+type SyncOptions struct {
+	ReadOnly    bool
+	RebuildBase bool
+	// Records progress, a journal that's already started is resumed. Nil
+	// keeps progress in memory.
+	Journal *SyncJournal
+}
+
+type PlaylistPlan struct {
+	Name   string
+	Tracks []spotify.ID
+}
+
+func PlanPlaylists(analysis *Analysis, options SyncOptions) []PlaylistPlan {
+	artistName := analysis.Catalog.Config.ArtistName
+
+	addingToExcluded := make([]spotify.ID, 0)
+	addingTo3OrMore := make([]spotify.ID, 0)
+	addingTo3OrMoreUnfiltered := make([]spotify.ID, 0)
+	addingToAll := make([]spotify.ID, 0)
+	addingToShort := make([]spotify.ID, 0)
+
+	for _, track := range analysis.Tracks {
+		addingToAll = append(addingToAll, track.ID)
+
+		if track.Duration < 60*1000 {
+			addingToShort = append(addingToShort, track.ID)
+		}
+
+		if track.Has3OrMoreRecordings {
+			if track.OnExcludedAlbum {
+				addingToExcluded = append(addingToExcluded, track.ID)
+			} else {
+				if !track.Excluded {
+					addingTo3OrMore = append(addingTo3OrMore, track.ID)
+				}
+
+				if !track.Excluded || track.Excluded && !track.Guessed {
+					addingTo3OrMoreUnfiltered = append(addingTo3OrMoreUnfiltered, track.ID)
+				}
+			}
+		}
+	}
+
+	byReleaseDate := make([]spotify.ID, 0)
+	originals := make([]spotify.ID, 0)
+	originalsUnfiltered := make([]spotify.ID, 0)
+
+	for _, track := range analysis.ByReleaseDate {
+		if track.Has3OrMoreRecordings {
+			if !track.Excluded {
+				byReleaseDate = append(byReleaseDate, track.ID)
+
+				if track.Original {
+					originals = append(originals, track.ID)
+				}
+			}
+			if track.Original {
+				if !track.Excluded || track.Excluded && !track.Guessed {
+					originalsUnfiltered = append(originalsUnfiltered, track.ID)
+				}
+			}
+		}
+	}
+
+	plans := []PlaylistPlan{
+		PlaylistPlan{artistName + " (R >= 3 unfiltered)", addingTo3OrMoreUnfiltered},
+		PlaylistPlan{artistName + " (R >= 3)", addingTo3OrMore},
+		PlaylistPlan{artistName + " (R >= 3 originals)", originals},
+		PlaylistPlan{artistName + " (R >= 3 originals unfiltered)", originalsUnfiltered},
+		PlaylistPlan{artistName + " (R >= 3 by release date)", byReleaseDate},
+		PlaylistPlan{artistName + " (R >= 3 on excluded albums)", addingToExcluded},
+	}
+
+	if options.RebuildBase {
+		plans = append(plans,
+			PlaylistPlan{artistName + " (all)", addingToAll},
+			PlaylistPlan{artistName + " (short)", addingToShort},
+		)
+	}
+
+	return plans
+}
+
+// Playlists are replaced wholesale, so nothing is synced from a catalog that's
+// missing albums or exclusions, that would drop or wrongly add tracks. Each
+// playlist is set on its own and a failure doesn't stop the rest. Once ctx is
+// done the playlist being written is finished and the rest are left for a
+// resumed sync, the journal is only removed once every playlist is set.
+func Sync(ctx context.Context, spotifyClient SpotifyClient, analysis *Analysis, options SyncOptions) error {
+	summary := analysis.Catalog.Summary
+
+	if summary.Failed("fetch") || summary.Failed("build") {
+		return fmt.Errorf("Not syncing, the catalog is incomplete")
+	}
+
+	plans := PlanPlaylists(analysis, options)
+
+	if options.ReadOnly {
+		for _, plan := range plans {
+			log.Printf("Setting %v tracks on '%s'", len(plan.Tracks), plan.Name)
+			summary.Succeed("sync", plan.Name)
+		}
+		return nil
+	}
+
+	user := analysis.Catalog.Config.User
+	current, err := spotifyClient.CurrentUser()
+	if err != nil {
+		return fmt.Errorf("Error getting current user: %v", err)
+	}
+	if current.ID != user {
+		return fmt.Errorf("Logged in as %s but the playlists are %s's, use --profile or --user to match", current.ID, user)
+	}
+
+	journal := options.Journal
+	if journal == nil {
+		journal = NewSyncJournal("")
+	}
+
+	plan := PlanChecksum(plans)
+	if journal.Plan == "" {
+		journal.Plan = plan
+	} else if journal.Plan != plan {
+		return fmt.Errorf("The playlists have changed since the interrupted sync, sync without --resume")
+	}
+
+	if err := journal.Save(); err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		if ctx.Err() != nil {
+			summary.Fail("sync", plan.Name, fmt.Errorf("Interrupted, run 'beatles sync --resume' to finish"))
+			continue
+		}
+
+		err := syncPlaylist(spotifyClient, analysis.Catalog.Config.User, plan, journal)
+		if err != nil {
+			summary.Fail("sync", plan.Name, fmt.Errorf("Error setting tracks: %v", err))
+			continue
+		}
+		summary.Succeed("sync", plan.Name)
+	}
+
+	if !summary.Failed("sync") {
+		return journal.Remove()
+	}
+
+	return nil
+}
+
+func syncPlaylist(spotifyClient SpotifyClient, user string, plan PlaylistPlan, journal *SyncJournal) error {
+	entry := journal.Playlist(plan.Name)
+	if entry.Complete {
+		log.Printf("Already set %v tracks on '%s'", len(plan.Tracks), plan.Name)
+		return nil
+	}
+
+	log.Printf("Setting %v tracks on '%s'", len(plan.Tracks), plan.Name)
+
+	playlist, err := GetPlaylist(spotifyClient, user, plan.Name)
+	if err != nil {
+		return err
+	}
+
+	// Followed playlists are listed alongside the user's own.
+	if playlist.Owner.ID != "" && playlist.Owner.ID != user {
+		return fmt.Errorf("'%s' is owned by %s, not %s", plan.Name, playlist.Owner.ID, user)
+	}
+
+	if entry.Started && entry.ID == playlist.ID {
+		err = ResumePlaylistTracks(spotifyClient, playlist.ID, plan.Tracks)
+	} else {
+		entry.ID = playlist.ID
+		entry.Started = true
+		if err := journal.Save(); err != nil {
+			return err
+		}
+		err = SetPlaylistTracks(spotifyClient, playlist.ID, plan.Tracks)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry.Complete = true
+
+	return journal.Save()
+}
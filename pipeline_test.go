@@ -0,0 +1,227 @@
+package beatles
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+type testCatalog struct {
+	fake   *FakeSpotify
+	config *Config
+	// Track IDs by name, every name is unique.
+	tracks map[string]spotify.ID
+}
+
+// Help comes first on 1963's album, Yesterday on 1965's. Twist is on an
+// excluded album, one recording of Yesterday is on an exclusion playlist and
+// Intro and Once are too short or too rare.
+func newTestCatalog() *testCatalog {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+
+	tc := &testCatalog{
+		fake: fake,
+		config: &Config{
+			User:       "tester",
+			ArtistID:   artist.ID,
+			ArtistName: "The Testers",
+			Workers:    2,
+		},
+		tracks: make(map[string]spotify.ID),
+	}
+
+	albums := []struct {
+		name, albumType, date string
+		tracks                []string
+	}{
+		{"Please", "album", "1963-03-22", []string{"Help", "Twist", "Intro"}},
+		{"Again", "album", "1965-08-06", []string{"Help - Remastered", "Yesterday", "Twist - Remastered"}},
+		{"Live", "album", "1970-01-01", []string{"Help - Live", "Yesterday - Live", "Once"}},
+		{"Hits", "compilation", "1973-04-02", []string{"Twist - Single"}},
+		{"Later", "album", "1975", []string{"Yesterday - Take 2"}},
+	}
+
+	for _, a := range albums {
+		precision := "day"
+		if len(a.date) == 4 {
+			precision = "year"
+		}
+		album := fake.AddAlbum(artist.ID, a.name, a.albumType, a.date, precision)
+		for _, name := range a.tracks {
+			duration := 180000
+			if name == "Intro" {
+				duration = 30000
+			}
+			tc.tracks[name] = fake.AddTrack(album.ID, name, duration, 50).ID
+		}
+		if a.name == "Hits" {
+			tc.config.ExcludedAlbums = append(tc.config.ExcludedAlbums, album.ID)
+		}
+	}
+
+	fake.AddPlaylist("tester", "The Testers (excluded)", tc.tracks["Yesterday - Live"])
+
+	return tc
+}
+
+func (tc *testCatalog) ids(names ...string) []spotify.ID {
+	ids := make([]spotify.ID, 0)
+	for _, name := range names {
+		ids = append(ids, tc.tracks[name])
+	}
+	return ids
+}
+
+// Fetches into a cache of the test's own, which fetching expects to be there.
+func (tc *testCatalog) analyze(t *testing.T) *Analysis {
+	t.Helper()
+
+	if err := os.MkdirAll(useCacheDirectory(t), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	catalog, err := Fetch(context.Background(), NewSpotifyCacher(tc.fake, false), tc.config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return Exclude(Group(Build(catalog)))
+}
+
+func sameOrder(a, b []spotify.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameIDs(a, b []spotify.ID) bool {
+	sortedA := append(make([]spotify.ID, 0), a...)
+	sortedB := append(make([]spotify.ID, 0), b...)
+	sort.Slice(sortedA, func(i, j int) bool { return sortedA[i] < sortedA[j] })
+	sort.Slice(sortedB, func(i, j int) bool { return sortedB[i] < sortedB[j] })
+	return sameOrder(sortedA, sortedB)
+}
+
+func TestPipelineSync(t *testing.T) {
+	tc := newTestCatalog()
+	analysis := tc.analyze(t)
+
+	if err := Sync(context.Background(), tc.fake, analysis, SyncOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if analysis.Catalog.Summary.Failed("") {
+		t.Fatalf("expected no failures")
+	}
+
+	expected := map[string][]spotify.ID{
+		"The Testers (R >= 3 unfiltered)":           tc.ids("Help", "Help - Remastered", "Help - Live", "Yesterday", "Yesterday - Live", "Yesterday - Take 2"),
+		"The Testers (R >= 3)":                      tc.ids("Help", "Help - Remastered", "Help - Live", "Yesterday", "Yesterday - Take 2"),
+		"The Testers (R >= 3 originals)":            tc.ids("Help", "Yesterday"),
+		"The Testers (R >= 3 originals unfiltered)": tc.ids("Help", "Yesterday", "Twist"),
+		"The Testers (R >= 3 by release date)":      tc.ids("Help", "Help - Remastered", "Help - Live", "Yesterday", "Yesterday - Take 2"),
+		"The Testers (R >= 3 on excluded albums)":   tc.ids("Twist", "Twist - Remastered", "Twist - Single"),
+	}
+
+	for name, ids := range expected {
+		playlist := tc.fake.GetPlaylistByName("tester", name)
+		if playlist == nil {
+			t.Errorf("expected '%s' to be created", name)
+			continue
+		}
+		if !sameIDs(playlist.TrackIDs, ids) {
+			t.Errorf("'%s' has %v, expected %v", name, playlist.TrackIDs, ids)
+		}
+	}
+
+	// Sorted before the songs' release dates are known, so ordered by name.
+	byReleaseDate := tc.fake.GetPlaylistByName("tester", "The Testers (R >= 3 by release date)")
+	if !sameOrder(byReleaseDate.TrackIDs, tc.ids("Help", "Help - Live", "Help - Remastered", "Yesterday", "Yesterday - Take 2")) {
+		t.Errorf("expected the order by name, got %v", byReleaseDate.TrackIDs)
+	}
+}
+
+func TestPipelineSyncReadOnly(t *testing.T) {
+	tc := newTestCatalog()
+	analysis := tc.analyze(t)
+
+	if err := Sync(context.Background(), tc.fake, analysis, SyncOptions{ReadOnly: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(tc.fake.Playlists) != 1 {
+		t.Fatalf("expected only the exclusion playlist, got %d playlists", len(tc.fake.Playlists))
+	}
+}
+
+func TestPipelineSyncWrongUser(t *testing.T) {
+	tc := newTestCatalog()
+	tc.fake.User.ID = "someone-else"
+	analysis := tc.analyze(t)
+
+	if err := Sync(context.Background(), tc.fake, analysis, SyncOptions{}); err == nil {
+		t.Fatalf("expected syncing as the wrong user to fail")
+	}
+	if len(tc.fake.Playlists) != 1 {
+		t.Fatalf("expected nothing created, got %d playlists", len(tc.fake.Playlists))
+	}
+}
+
+func TestPipelineSyncIncompleteCatalog(t *testing.T) {
+	tc := newTestCatalog()
+	tc.config.ExcludedAlbums = append(tc.config.ExcludedAlbums, "al-missing")
+	analysis := tc.analyze(t)
+
+	if !analysis.Catalog.Summary.Failed("fetch") {
+		t.Fatalf("expected the missing album to fail the fetch")
+	}
+	if err := Sync(context.Background(), tc.fake, analysis, SyncOptions{}); err == nil {
+		t.Fatalf("expected an incomplete catalog not to sync")
+	}
+	if len(tc.fake.Playlists) != 1 {
+		t.Fatalf("expected nothing created, got %d playlists", len(tc.fake.Playlists))
+	}
+}
+
+func TestPipelineCatalogOnly(t *testing.T) {
+	tc := newTestCatalog()
+	tc.config.CatalogOnly = true
+	if err := os.MkdirAll(useCacheDirectory(t), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Nothing cached yet, there's no reading playlists as the app.
+	catalog, err := Fetch(context.Background(), NewSpotifyCacher(tc.fake, false), tc.config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !catalog.Summary.Failed("fetch") {
+		t.Fatalf("expected missing exclusion playlists to fail the fetch")
+	}
+
+	tc.config.CatalogOnly = false
+	if _, err := Fetch(context.Background(), NewSpotifyCacher(tc.fake, false), tc.config); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tc.config.CatalogOnly = true
+	catalog, err = Fetch(context.Background(), NewSpotifyCacher(tc.fake, false), tc.config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if catalog.Summary.Failed("fetch") || len(catalog.ExclusionPlaylists) != 1 {
+		t.Fatalf("expected the exclusion playlist from the cache")
+	}
+	if notes := catalog.Summary.Notes; len(notes) != 1 || !strings.Contains(notes[0], "Read from the cache") {
+		t.Fatalf("expected a note that playlists came from the cache, got %v", notes)
+	}
+}
@@ -0,0 +1,114 @@
+package beatles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/zmb3/spotify"
+)
+
+const DefaultProfilesFile = "profiles.json"
+
+// The Beatles' compilations, excluded unless a profile lists its own.
+const DefaultExcludedAlbums = "3PRoXYsngSwjEQWR5PsHWR,1klALx0u4AavZNEvC4LrTL,6QaVfG1pHYl1z15ZxkvVDW"
+
+// A Spotify identity with its own token store and cache, so that runs as one
+// user never read or write another's. Profiles needn't be listed in
+// profiles.json, one that isn't gets the default files for its name and the
+// --user it's given.
+type Profile struct {
+	Name    string `json:"-"`
+	User    string `json:"user"`
+	Tokens  string `json:"tokens"`
+	Cache   string `json:"cache"`
+	Journal string `json:"journal"`
+	// Comma separated, what sync excludes is what prune keeps.
+	ExcludedAlbums string `json:"excluded_albums"`
+	// Where the tokens were kept before they moved to the config directory.
+	legacyTokens string
+}
+
+// The unnamed profile is the layout from before profiles, .cache and
+// tokens.json, though the tokens now live in the user's config directory.
+func LoadProfile(path, name string) (*Profile, error) {
+	if path == "" {
+		path = os.Getenv("BEATLES_PROFILES")
+	}
+	if path == "" {
+		path = DefaultProfilesFile
+	}
+
+	profiles := make(map[string]*Profile)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading profiles: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return nil, fmt.Errorf("Error parsing profiles %s: %v", path, err)
+		}
+	}
+
+	profile, ok := profiles[name]
+	if !ok || profile == nil {
+		profile = &Profile{}
+	}
+
+	profile.Name = name
+
+	// Tokens default to the config directory, which is only looked for once
+	// they're needed.
+	if profile.Tokens == "" {
+		profile.legacyTokens = DefaultTokensFile
+		if name != "" {
+			profile.legacyTokens = fmt.Sprintf("tokens-%s.json", name)
+		}
+	}
+	if profile.Cache == "" {
+		profile.Cache = DefaultCacheDirectory
+		if name != "" {
+			profile.Cache = fmt.Sprintf("%s-%s", DefaultCacheDirectory, name)
+		}
+	}
+	if profile.Journal == "" {
+		profile.Journal = SyncJournalFile
+		if name != "" {
+			profile.Journal = fmt.Sprintf("sync-journal-%s.json", name)
+		}
+	}
+	if profile.ExcludedAlbums == "" {
+		profile.ExcludedAlbums = DefaultExcludedAlbums
+	}
+
+	return profile, nil
+}
+
+// Points the token store and cache at the profile's, the journal's left to
+// whoever syncs.
+func (p *Profile) Apply() {
+	if p.Name != "" {
+		log.Printf("Profile %s, cache in %s", p.Name, p.Cache)
+	}
+
+	if p.Tokens == "" {
+		SetDefaultTokensFile(p.Name, p.legacyTokens)
+	} else {
+		SetTokensFile(p.Tokens, p.legacyTokens)
+	}
+	SetCacheDirectory(p.Cache)
+}
+
+func ParseAlbumIDs(list string) []spotify.ID {
+	ids := make([]spotify.ID, 0)
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, spotify.ID(id))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,233 @@
+package beatles
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRequestsPerSecond = 10.0
+	DefaultMaximumRetries    = 6
+	DefaultMinimumBackoff    = 500 * time.Millisecond
+	DefaultMaximumBackoff    = 30 * time.Second
+	DefaultRequestTimeout    = 30 * time.Second
+)
+
+// An http.RoundTripper that spaces requests out to a maximum rate and retries
+// them when Spotify pushes back. A 429 is retried whatever the method, the
+// request was never processed, and every request waits out its Retry-After.
+// Network errors, timeouts included, and 5xx responses are only retried for
+// idempotent methods. Each attempt gets its own Timeout.
+type RetryTransport struct {
+	Base              http.RoundTripper
+	RequestsPerSecond float64
+	MaximumRetries    int
+	MinimumBackoff    time.Duration
+	MaximumBackoff    time.Duration
+	Timeout           time.Duration
+	lock              sync.Mutex
+	next              time.Time
+}
+
+func NewRetryTransport(base http.RoundTripper) *RetryTransport {
+	return &RetryTransport{
+		Base:              base,
+		RequestsPerSecond: DefaultRequestsPerSecond,
+		MaximumRetries:    DefaultMaximumRetries,
+		MinimumBackoff:    DefaultMinimumBackoff,
+		MaximumBackoff:    DefaultMaximumBackoff,
+		Timeout:           DefaultRequestTimeout,
+	}
+}
+
+// Reserves the next slot, returning how long to wait for it.
+func (rt *RetryTransport) reserve() time.Duration {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	now := time.Now()
+	if rt.next.Before(now) {
+		rt.next = now
+	}
+
+	delay := rt.next.Sub(now)
+	if rt.RequestsPerSecond > 0 {
+		rt.next = rt.next.Add(time.Duration(float64(time.Second) / rt.RequestsPerSecond))
+	}
+
+	return delay
+}
+
+// Holds back every request, not just the throttled one.
+func (rt *RetryTransport) pause(delay time.Duration) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if until := time.Now().Add(delay); until.After(rt.next) {
+		rt.next = until
+	}
+}
+
+func (rt *RetryTransport) backoff(attempt int) time.Duration {
+	delay := rt.MinimumBackoff << uint(attempt)
+	if delay <= 0 || delay > rt.MaximumBackoff {
+		delay = rt.MaximumBackoff
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Retry-After is either a number of seconds or an HTTP date.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+func (rt *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := sleepContext(req, rt.reserve()); err != nil {
+			return nil, err
+		}
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if rt.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(req.Context(), rt.Timeout)
+		} else {
+			ctx, cancel = context.WithCancel(req.Context())
+		}
+
+		outgoing := req.WithContext(ctx)
+		if body != nil {
+			outgoing.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		res, err := rt.Base.RoundTrip(outgoing)
+		if err != nil {
+			cancel()
+		} else {
+			res.Body = &cancelBody{res.Body, cancel}
+		}
+
+		if attempt >= rt.MaximumRetries || req.Context().Err() != nil {
+			return res, err
+		}
+
+		var delay time.Duration
+		var reason string
+		switch {
+		case err != nil && idempotent(req.Method):
+			delay, reason = rt.backoff(attempt), err.Error()
+		case err != nil:
+			return res, err
+		case res.StatusCode == http.StatusTooManyRequests:
+			reason = res.Status
+			if after, ok := retryAfter(res); ok {
+				delay = after
+			} else {
+				delay = rt.backoff(attempt)
+			}
+			rt.pause(delay)
+		case res.StatusCode >= 500 && idempotent(req.Method):
+			reason = res.Status
+			if after, ok := retryAfter(res); ok {
+				delay = after
+			} else {
+				delay = rt.backoff(attempt)
+			}
+		default:
+			return res, err
+		}
+
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		log.Printf("Throttled: %s %s (%s), retrying in %v (attempt %d of %d)", req.Method, req.URL.Path, reason, delay.Round(time.Millisecond), attempt+1, rt.MaximumRetries)
+
+		if err := sleepContext(req, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleepContext(req *http.Request, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// Releases an attempt's timeout once its response has been read.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
+
+// Binds requests to ctx, zmb3/spotify makes every request without a context
+// of its own.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (ct *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := ct.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ct.base.RoundTrip(req.WithContext(ct.ctx))
+}
@@ -0,0 +1,232 @@
+package beatles
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Counts requests and answers each with the response for its number, the
+// last one over again once they run out.
+type scriptedServer struct {
+	lock      sync.Mutex
+	responses []func(w http.ResponseWriter, r *http.Request)
+	requests  int
+	bodies    []string
+}
+
+func (ss *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	ss.lock.Lock()
+	ss.requests += 1
+	ss.bodies = append(ss.bodies, string(body))
+	respond := ss.responses[min(ss.requests, len(ss.responses))-1]
+	ss.lock.Unlock()
+
+	respond(w, r)
+}
+
+func (ss *scriptedServer) count() int {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.requests
+}
+
+func respondWith(status int, header ...string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.WriteHeader(status)
+	}
+}
+
+// Takes longer than the test's per-attempt timeout, or until the request's
+// abandoned.
+func respondSlowly(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-time.After(5 * time.Second):
+		w.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+	}
+}
+
+func newTestRetryTransport() *RetryTransport {
+	rt := NewRetryTransport(http.DefaultTransport)
+	rt.RequestsPerSecond = 0
+	rt.MaximumRetries = 3
+	rt.MinimumBackoff = time.Millisecond
+	rt.MaximumBackoff = 5 * time.Millisecond
+	rt.Timeout = 0
+	return rt
+}
+
+func doRequest(t *testing.T, rt *RetryTransport, method, url, body string) (*http.Response, error) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	res, err := rt.RoundTrip(req)
+	if err == nil {
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	return res, err
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	ss := &scriptedServer{
+		responses: []func(w http.ResponseWriter, r *http.Request){
+			respondWith(http.StatusTooManyRequests, "Retry-After", "1"),
+			respondWith(http.StatusOK),
+		},
+	}
+	server := httptest.NewServer(ss)
+	defer server.Close()
+
+	rt := newTestRetryTransport()
+
+	// A POST too, a throttled request was never processed.
+	started := time.Now()
+	res, err := doRequest(t, rt, "POST", server.URL, "adding")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the retry to succeed, got %d", res.StatusCode)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Fatalf("expected to wait out Retry-After, retried after %v", elapsed)
+	}
+	if ss.count() != 2 || ss.bodies[1] != "adding" {
+		t.Fatalf("expected one retry with the same body, got %d requests %v", ss.count(), ss.bodies)
+	}
+
+	// Other requests are held back until the pause is over.
+	if wait := rt.reserve(); wait > 0 {
+		t.Fatalf("expected the pause to be over, waiting %v", wait)
+	}
+	rt.pause(time.Hour)
+	if wait := rt.reserve(); wait < 59*time.Minute {
+		t.Fatalf("expected the next request to wait out the pause, waiting %v", wait)
+	}
+}
+
+func TestRetryTransportMaximumRetries(t *testing.T) {
+	ss := &scriptedServer{
+		responses: []func(w http.ResponseWriter, r *http.Request){
+			respondWith(http.StatusServiceUnavailable),
+		},
+	}
+	server := httptest.NewServer(ss)
+	defer server.Close()
+
+	res, err := doRequest(t, newTestRetryTransport(), "GET", server.URL, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last 503 back, got %d", res.StatusCode)
+	}
+	if ss.count() != 4 {
+		t.Fatalf("expected the first request and 3 retries, got %d", ss.count())
+	}
+}
+
+func TestRetryTransportNonIdempotent(t *testing.T) {
+	ss := &scriptedServer{
+		responses: []func(w http.ResponseWriter, r *http.Request){
+			respondWith(http.StatusServiceUnavailable),
+			respondWith(http.StatusCreated),
+		},
+	}
+	server := httptest.NewServer(ss)
+	defer server.Close()
+
+	// The tracks may have been added, adding them again would duplicate them.
+	res, err := doRequest(t, newTestRetryTransport(), "POST", server.URL, "adding")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.StatusCode != http.StatusServiceUnavailable || ss.count() != 1 {
+		t.Fatalf("expected the 503 back without a retry, got %d after %d requests", res.StatusCode, ss.count())
+	}
+
+	// A PUT replaces, it's safe to repeat.
+	res, err = doRequest(t, newTestRetryTransport(), "PUT", server.URL, "replacing")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.StatusCode != http.StatusCreated || ss.count() != 2 {
+		t.Fatalf("expected the PUT to be retried, got %d after %d requests", res.StatusCode, ss.count())
+	}
+}
+
+func TestRetryTransportTimeout(t *testing.T) {
+	ss := &scriptedServer{
+		responses: []func(w http.ResponseWriter, r *http.Request){
+			respondSlowly,
+			respondWith(http.StatusOK),
+		},
+	}
+	server := httptest.NewServer(ss)
+	defer server.Close()
+
+	rt := newTestRetryTransport()
+	rt.Timeout = 100 * time.Millisecond
+
+	// Each attempt gets its own timeout, the retry has the whole of it.
+	res, err := doRequest(t, rt, "GET", server.URL, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.StatusCode != http.StatusOK || ss.count() != 2 {
+		t.Fatalf("expected the timed out GET to be retried, got %d after %d requests", res.StatusCode, ss.count())
+	}
+
+	// A POST that timed out may still have happened.
+	ss.lock.Lock()
+	ss.responses = ss.responses[:1]
+	ss.lock.Unlock()
+	started := time.Now()
+	_, err = doRequest(t, rt, "POST", server.URL, "adding")
+	if err == nil {
+		t.Fatalf("expected the POST to time out")
+	}
+	if ss.count() != 3 {
+		t.Fatalf("expected the POST not to be retried, got %d requests", ss.count())
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("expected the POST to give up after its timeout, took %v", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	res := &http.Response{Header: http.Header{}}
+	if _, ok := retryAfter(res); ok {
+		t.Fatalf("expected no Retry-After")
+	}
+
+	res.Header.Set("Retry-After", "3")
+	if delay, ok := retryAfter(res); !ok || delay != 3*time.Second {
+		t.Fatalf("expected 3s, got %v", delay)
+	}
+
+	res.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if delay, ok := retryAfter(res); !ok || delay < 58*time.Second || delay > time.Minute {
+		t.Fatalf("expected about a minute, got %v", delay)
+	}
+
+	res.Header.Set("Retry-After", "soon")
+	if _, ok := retryAfter(res); ok {
+		t.Fatalf("expected an unparseable Retry-After to be ignored")
+	}
+}
@@ -0,0 +1,54 @@
+package beatles
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/zmb3/spotify"
+)
+
+// A mutating call on a ReadOnlyClient.
+type ReadOnlyError struct {
+	Operation string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("Read only, refused to %s", e.Operation)
+}
+
+// Passes reads through and refuses everything that changes the account,
+// logging what would have happened. Any mutating method added to
+// SpotifyClient needs refusing here too.
+type ReadOnlyClient struct {
+	SpotifyClient
+}
+
+var _ SpotifyClient = (*ReadOnlyClient)(nil)
+
+func NewReadOnlyClient(client SpotifyClient) *ReadOnlyClient {
+	if ro, ok := client.(*ReadOnlyClient); ok {
+		return ro
+	}
+	return &ReadOnlyClient{SpotifyClient: client}
+}
+
+func refuse(operation string) error {
+	log.Printf("Read only, refusing to %s", operation)
+	return &ReadOnlyError{Operation: operation}
+}
+
+func (ro *ReadOnlyClient) CreatePlaylistForUser(user, name, description string, public bool) (*spotify.FullPlaylist, error) {
+	return nil, refuse(fmt.Sprintf("create playlist '%s' for %s", name, user))
+}
+
+func (ro *ReadOnlyClient) AddTracksToPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	return "", refuse(fmt.Sprintf("add %d tracks to %s", len(ids), id))
+}
+
+func (ro *ReadOnlyClient) RemoveTracksFromPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	return "", refuse(fmt.Sprintf("remove %d tracks from %s", len(ids), id))
+}
+
+func (ro *ReadOnlyClient) ReplacePlaylistTracks(id spotify.ID, ids ...spotify.ID) error {
+	return refuse(fmt.Sprintf("replace the tracks of %s with %d tracks", id, len(ids)))
+}
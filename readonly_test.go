@@ -0,0 +1,76 @@
+package beatles
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+// Fails the test on any write that gets through to it.
+type writeTrap struct {
+	SpotifyClient
+	t *testing.T
+}
+
+func (wt *writeTrap) CreatePlaylistForUser(user, name, description string, public bool) (*spotify.FullPlaylist, error) {
+	wt.t.Errorf("expected creating '%s' to be refused", name)
+	return nil, nil
+}
+
+func (wt *writeTrap) AddTracksToPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	wt.t.Errorf("expected adding to %s to be refused", id)
+	return "", nil
+}
+
+func (wt *writeTrap) RemoveTracksFromPlaylist(id spotify.ID, ids ...spotify.ID) (string, error) {
+	wt.t.Errorf("expected removing from %s to be refused", id)
+	return "", nil
+}
+
+func (wt *writeTrap) ReplacePlaylistTracks(id spotify.ID, ids ...spotify.ID) error {
+	wt.t.Errorf("expected replacing %s to be refused", id)
+	return nil
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	playlist := fake.AddPlaylist("tester", "Kept")
+	client := NewReadOnlyClient(&writeTrap{SpotifyClient: fake, t: t})
+
+	for name, write := range map[string]func() error{
+		"create": func() error {
+			_, err := client.CreatePlaylistForUser("tester", "Anything", "", true)
+			return err
+		},
+		"add": func() error {
+			_, err := client.AddTracksToPlaylist(playlist.ID, "tr-1")
+			return err
+		},
+		"remove": func() error {
+			_, err := client.RemoveTracksFromPlaylist(playlist.ID, "tr-1")
+			return err
+		},
+		"replace": func() error {
+			return client.ReplacePlaylistTracks(playlist.ID, "tr-1")
+		},
+	} {
+		var readOnly *ReadOnlyError
+		if err := write(); !errors.As(err, &readOnly) {
+			t.Errorf("expected %s to fail with a ReadOnlyError, got %v", name, err)
+		}
+	}
+
+	// Reads pass through.
+	found, err := GetPlaylistByTitle(client, "tester", "Kept")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if found == nil || found.ID != playlist.ID {
+		t.Fatalf("expected to read the playlist, got %v", found)
+	}
+
+	if NewReadOnlyClient(client) != client {
+		t.Fatalf("expected a read only client not to be wrapped again")
+	}
+}
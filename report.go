@@ -0,0 +1,318 @@
+package beatles
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+var ReportTemplates = map[string]string{
+	"tracks.org.template":     "tracks.org",
+	"excluded.org.template":   "excluded.org",
+	"candidates.org.template": "candidates.org",
+	"all.org.template":        "all.org",
+}
+
+// Rendered inside HtmlReportLayout, each defines a title and content.
+var HtmlReportTemplates = map[string]string{
+	"index.html.template":      "index.html",
+	"tracks.html.template":     "tracks.html",
+	"excluded.html.template":   "excluded.html",
+	"candidates.html.template": "candidates.html",
+	"all.html.template":        "all.html",
+}
+
+const HtmlReportLayout = "report.html.template"
+
+// Copied next to the HTML reports, which link to them relatively so the
+// directory can be served or opened from anywhere.
+var HtmlReportAssets = []string{"report.css", "report.js"}
+
+type ReportOptions struct {
+	// Also render the reports as HTML, alongside the org files.
+	Html bool
+}
+
+// An album of the discography with the tracks it has, a track on several
+// albums is on each.
+type ReportAlbum struct {
+	ID          spotify.ID
+	Name        string
+	ReleaseDate time.Time
+	Excluded    bool
+	Tracks      []*TrackInfo
+}
+
+// Tracks excluded by one rule, see ExclusionRule.
+type ReportExclusion struct {
+	Rule   string
+	Tracks []*TrackInfo
+}
+
+// Where the reports came from.
+type ReportRun struct {
+	Generated time.Time
+	Config    *Config
+	Artist    *spotify.FullArtist
+	Failures  []*Failure
+}
+
+type ReportStats struct {
+	Tracks   int
+	Songs    int
+	Albums   int
+	Included int
+	Excluded int
+	Guessed  int
+	Short    int
+	Original int
+	// Milliseconds, of every track.
+	Duration int
+}
+
+// What every report template is executed with.
+type ReportData struct {
+	ByName        []*TrackInfo
+	ByPopularity  []*TrackInfo
+	ByReleaseDate []*TrackInfo
+	Songs         []*Song
+	Albums        []*ReportAlbum
+	Exclusions    []*ReportExclusion
+	Audit         []AuditEntry
+	Run           ReportRun
+	Stats         ReportStats
+}
+
+func NewReportData(analysis *Analysis) *ReportData {
+	catalog := analysis.Catalog
+
+	byPopularity := make([]*TrackInfo, len(analysis.Tracks))
+	copy(byPopularity, analysis.Tracks)
+	sort.Sort(ByPopularity(byPopularity))
+
+	data := &ReportData{
+		ByName:        analysis.Tracks,
+		ByPopularity:  byPopularity,
+		ByReleaseDate: analysis.ByReleaseDate,
+		Songs:         analysis.Songs,
+		Albums:        make([]*ReportAlbum, 0),
+		Exclusions:    make([]*ReportExclusion, 0),
+		Audit:         analysis.Audit.Entries,
+		Run: ReportRun{
+			Generated: time.Now(),
+			Config:    catalog.Config,
+			Artist:    catalog.Artist,
+			Failures:  catalog.Summary.Failures,
+		},
+	}
+
+	tracks := make(map[spotify.ID]*TrackInfo)
+	for _, track := range analysis.Tracks {
+		tracks[track.ID] = track
+	}
+
+	excluded := make(map[spotify.ID]bool)
+	for _, id := range catalog.Config.ExcludedAlbums {
+		excluded[id] = true
+	}
+
+	for _, album := range catalog.Albums {
+		releaseDate, _ := ParseReleaseDate(album.Album.ReleaseDate)
+		reportAlbum := &ReportAlbum{
+			ID:          album.Album.ID,
+			Name:        album.Album.Name,
+			ReleaseDate: releaseDate,
+			Excluded:    excluded[album.Album.ID],
+			Tracks:      make([]*TrackInfo, 0),
+		}
+		for _, track := range album.Tracks {
+			if info, ok := tracks[track.ID]; ok {
+				reportAlbum.Tracks = append(reportAlbum.Tracks, info)
+			}
+		}
+		data.Albums = append(data.Albums, reportAlbum)
+	}
+
+	byRule := make(map[string]*ReportExclusion)
+	for _, track := range analysis.Tracks {
+		for _, reason := range track.ExcludedReasons {
+			rule := ExclusionRule(reason)
+			exclusion, ok := byRule[rule]
+			if !ok {
+				exclusion = &ReportExclusion{Rule: rule}
+				byRule[rule] = exclusion
+				data.Exclusions = append(data.Exclusions, exclusion)
+			}
+			if n := len(exclusion.Tracks); n == 0 || exclusion.Tracks[n-1] != track {
+				exclusion.Tracks = append(exclusion.Tracks, track)
+			}
+		}
+	}
+	sort.Slice(data.Exclusions, func(i, j int) bool {
+		return data.Exclusions[i].Rule < data.Exclusions[j].Rule
+	})
+
+	stats := &data.Stats
+	stats.Tracks = len(analysis.Tracks)
+	stats.Songs = len(analysis.Songs)
+	stats.Albums = len(catalog.Albums)
+	for _, track := range analysis.Tracks {
+		if track.Has3OrMoreRecordings && !track.Excluded {
+			stats.Included += 1
+		}
+		if track.Excluded {
+			stats.Excluded += 1
+		}
+		if track.Guessed {
+			stats.Guessed += 1
+		}
+		if track.Duration < 60*1000 {
+			stats.Short += 1
+		}
+		if track.Original {
+			stats.Original += 1
+		}
+		stats.Duration += track.Duration
+	}
+
+	return data
+}
+
+// Each report is rendered on its own, one that fails doesn't stop the rest.
+func GenerateTable(data *ReportData, directory string, summary *Summary, options ReportOptions) {
+
+	templateNames := make([]string, 0)
+	for templateName := range ReportTemplates {
+		templateNames = append(templateNames, templateName)
+	}
+	sort.Strings(templateNames)
+
+	for _, templateName := range templateNames {
+		path := filepath.Join(directory, ReportTemplates[templateName])
+		err := renderTemplate(templateName, path, data)
+		if err != nil {
+			summary.Fail("report", path, err)
+			continue
+		}
+		summary.Succeed("report", path)
+	}
+
+	if !options.Html {
+		return
+	}
+
+	templateNames = make([]string, 0)
+	for templateName := range HtmlReportTemplates {
+		templateNames = append(templateNames, templateName)
+	}
+	sort.Strings(templateNames)
+
+	for _, templateName := range templateNames {
+		path := filepath.Join(directory, HtmlReportTemplates[templateName])
+		err := renderHtmlTemplate(templateName, path, data)
+		if err != nil {
+			summary.Fail("report", path, err)
+			continue
+		}
+		summary.Succeed("report", path)
+	}
+
+	for _, asset := range HtmlReportAssets {
+		path := filepath.Join(directory, asset)
+		err := copyReportAsset(asset, path)
+		if err != nil {
+			summary.Fail("report", path, err)
+			continue
+		}
+		summary.Succeed("report", path)
+	}
+}
+
+func renderTemplate(templateName, path string, data interface{}) error {
+	templateData, err := ioutil.ReadFile(filepath.Join("./", templateName))
+	if err != nil {
+		return fmt.Errorf("Error reading template: %v", err)
+	}
+
+	template, err := template.New(filepath.Base(path)).Funcs(ReportFuncs).Parse(string(templateData))
+	if err != nil {
+		return fmt.Errorf("Error parsing template: %v", err)
+	}
+
+	log.Printf("Writing %s", path)
+
+	var buffer bytes.Buffer
+	err = template.Execute(&buffer, data)
+	if err != nil {
+		return fmt.Errorf("Error executing template: %v", err)
+	}
+
+	return WriteFileAtomic(path, buffer.Bytes(), 0644)
+}
+
+// Executes the layout with templateName's title and content.
+func renderHtmlTemplate(templateName, path string, data interface{}) error {
+	template, err := htmltemplate.New(filepath.Base(path)).Funcs(ReportFuncs).ParseFiles(HtmlReportLayout, templateName)
+	if err != nil {
+		return fmt.Errorf("Error parsing template: %v", err)
+	}
+
+	log.Printf("Writing %s", path)
+
+	var buffer bytes.Buffer
+	err = template.ExecuteTemplate(&buffer, "layout", data)
+	if err != nil {
+		return fmt.Errorf("Error executing template: %v", err)
+	}
+
+	return WriteFileAtomic(path, buffer.Bytes(), 0644)
+}
+
+func copyReportAsset(asset, path string) error {
+	data, err := ioutil.ReadFile(filepath.Join("./", asset))
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", asset, err)
+	}
+
+	return WriteFileAtomic(path, data, 0644)
+}
+
+type AuditEntry struct {
+	Track  string
+	Reason string
+}
+
+type AuditLog struct {
+	Entries []AuditEntry
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{
+		Entries: make([]AuditEntry, 0),
+	}
+}
+
+func (al *AuditLog) Append(track, reason string) {
+	al.Entries = append(al.Entries, AuditEntry{
+		Track:  track,
+		Reason: reason,
+	})
+}
+
+func (al *AuditLog) Write(path string) error {
+	var buffer bytes.Buffer
+
+	for _, entry := range al.Entries {
+		buffer.WriteString(fmt.Sprintf("| %s | %s |\n", entry.Track, entry.Reason))
+	}
+
+	return WriteFileAtomic(path, buffer.Bytes(), 0644)
+}
@@ -0,0 +1,75 @@
+// Makes every table.report sortable by clicking a heading and filterable by
+// typing in a box above it. Cells sort by their data-sort attribute when they
+// have one, numerically when both sides are numbers.
+(function () {
+  function sortKey(cell) {
+    return cell.hasAttribute("data-sort") ? cell.getAttribute("data-sort") : cell.textContent.trim();
+  }
+
+  function compare(a, b) {
+    var x = parseFloat(a), y = parseFloat(b);
+    if (!isNaN(x) && !isNaN(y) && String(x) === a && String(y) === b) {
+      return x - y;
+    }
+    return a.localeCompare(b);
+  }
+
+  function sortable(table) {
+    var headings = table.querySelectorAll("thead th");
+    var body = table.tBodies[0];
+
+    headings.forEach(function (heading, column) {
+      heading.addEventListener("click", function () {
+        var descending = heading.classList.contains("ascending");
+        headings.forEach(function (other) {
+          other.classList.remove("ascending", "descending");
+        });
+        heading.classList.add(descending ? "descending" : "ascending");
+
+        var rows = Array.prototype.slice.call(body.rows);
+        rows.sort(function (a, b) {
+          var order = compare(sortKey(a.cells[column]), sortKey(b.cells[column]));
+          return descending ? -order : order;
+        });
+        rows.forEach(function (row) {
+          body.appendChild(row);
+        });
+      });
+    });
+  }
+
+  function filterable(table) {
+    var body = table.tBodies[0];
+    var input = document.createElement("input");
+    var count = document.createElement("span");
+
+    input.type = "search";
+    input.className = "filter";
+    input.placeholder = "Filter " + body.rows.length + " rows";
+    count.className = "count";
+
+    input.addEventListener("input", function () {
+      var words = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+      var shown = 0;
+      Array.prototype.forEach.call(body.rows, function (row) {
+        var text = row.textContent.toLowerCase();
+        var match = words.every(function (word) {
+          return text.indexOf(word) >= 0;
+        });
+        row.hidden = !match;
+        if (match) {
+          shown++;
+        }
+      });
+      count.textContent = words.length > 0 ? shown + " of " + body.rows.length : "";
+    });
+
+    table.parentNode.insertBefore(input, table);
+    table.parentNode.insertBefore(count, table);
+  }
+
+  document.querySelectorAll("table.report").forEach(function (table) {
+    sortable(table);
+    filterable(table);
+  });
+})();
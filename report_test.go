@@ -0,0 +1,52 @@
+package beatles
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportRendersEveryTemplate(t *testing.T) {
+	tc := newTestCatalog()
+	analysis := tc.analyze(t)
+	directory := t.TempDir()
+
+	if err := Report(analysis, directory, ReportOptions{Html: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, failure := range analysis.Catalog.Summary.Failures {
+		t.Errorf("%v", failure)
+	}
+
+	files := []string{"audit.org", "audit.html"}
+	for _, file := range ReportTemplates {
+		files = append(files, file)
+	}
+	for _, file := range HtmlReportTemplates {
+		files = append(files, file)
+	}
+	files = append(files, HtmlReportAssets...)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(directory, file))
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		if len(data) == 0 {
+			t.Errorf("expected %s to have something in it", file)
+		}
+	}
+
+	// Every track's on the page of all of them, escaped as HTML.
+	all, err := ioutil.ReadFile(filepath.Join(directory, "all.html"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name := range tc.tracks {
+		if !strings.Contains(string(all), name) {
+			t.Errorf("expected %s in all.html", name)
+		}
+	}
+}
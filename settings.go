@@ -0,0 +1,124 @@
+package beatles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const DefaultCredentialsFile = "credentials.json"
+
+const (
+	DefaultSpotifyRedirectUrl = "http://local.page5of4.com:9090/spotify/callback"
+	DefaultCallbackAddress    = ":9090"
+)
+
+// The app's credentials and how logins come back to it. Each is read from
+// its environment variable, falling back to the credentials file and then to
+// a default, so nothing secret is compiled in.
+type AuthSettings struct {
+	SpotifyClientId     string `json:"spotify_client_id"`
+	SpotifyClientSecret string `json:"spotify_client_secret"`
+	SpotifyRedirectUrl  string `json:"spotify_redirect_url"`
+	CallbackAddress     string `json:"callback_address"`
+	path                string
+}
+
+type authSetting struct {
+	env   string
+	key   string
+	value func(as *AuthSettings) *string
+}
+
+var authSettingsFields = []authSetting{
+	{"SPOTIFY_CLIENT_ID", "spotify_client_id", func(as *AuthSettings) *string { return &as.SpotifyClientId }},
+	{"SPOTIFY_CLIENT_SECRET", "spotify_client_secret", func(as *AuthSettings) *string { return &as.SpotifyClientSecret }},
+	{"SPOTIFY_REDIRECT_URL", "spotify_redirect_url", func(as *AuthSettings) *string { return &as.SpotifyRedirectUrl }},
+	{"BEATLES_CALLBACK_ADDRESS", "callback_address", func(as *AuthSettings) *string { return &as.CallbackAddress }},
+}
+
+var authSettings *AuthSettings
+
+// Reads the credentials file at path, which needn't exist, and then the
+// environment. An empty path is BEATLES_CREDENTIALS or credentials.json.
+func LoadAuthSettings(path string) (*AuthSettings, error) {
+	if path == "" {
+		path = os.Getenv("BEATLES_CREDENTIALS")
+	}
+	if path == "" {
+		path = DefaultCredentialsFile
+	}
+
+	settings := &AuthSettings{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading credentials: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, settings); err != nil {
+			return nil, fmt.Errorf("Error parsing credentials %s: %v", path, err)
+		}
+	}
+
+	for _, field := range authSettingsFields {
+		if value := os.Getenv(field.env); value != "" {
+			*field.value(settings) = value
+		}
+	}
+
+	if settings.SpotifyRedirectUrl == "" {
+		settings.SpotifyRedirectUrl = DefaultSpotifyRedirectUrl
+	}
+	if settings.CallbackAddress == "" {
+		settings.CallbackAddress = DefaultCallbackAddress
+	}
+
+	return settings, nil
+}
+
+// Replaces the settings used by everything authenticating with Spotify.
+func SetAuthSettings(settings *AuthSettings) {
+	authSettings = settings
+}
+
+// The settings set last, loaded from the default places if none were.
+func CurrentAuthSettings() (*AuthSettings, error) {
+	if authSettings != nil {
+		return authSettings, nil
+	}
+
+	settings, err := LoadAuthSettings("")
+	if err != nil {
+		return nil, err
+	}
+
+	authSettings = settings
+
+	return settings, nil
+}
+
+// Fails naming every missing setting, and where each can be given.
+func (as *AuthSettings) require(keys ...string) error {
+	missing := make([]string, 0)
+	for _, field := range authSettingsFields {
+		for _, key := range keys {
+			if field.key == key && *field.value(as) == "" {
+				missing = append(missing, fmt.Sprintf("%s (or %s in %s)", field.env, field.key, as.path))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing Spotify settings: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Every flow needs the app's ID and secret.
+func (as *AuthSettings) Require() error {
+	return as.require("spotify_client_id", "spotify_client_secret")
+}
@@ -0,0 +1,72 @@
+package beatles
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useAuthEnvironment(t *testing.T) {
+	t.Helper()
+
+	for _, field := range authSettingsFields {
+		t.Setenv(field.env, "")
+	}
+	t.Setenv("BEATLES_CREDENTIALS", "")
+}
+
+func TestLoadAuthSettings(t *testing.T) {
+	useAuthEnvironment(t)
+
+	path := filepath.Join(t.TempDir(), DefaultCredentialsFile)
+	credentials := `{"spotify_client_id":"file-id","spotify_client_secret":"file-secret","spotify_redirect_url":"http://localhost:8080/callback"}`
+	if err := ioutil.WriteFile(path, []byte(credentials), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The environment wins over the file, which wins over the defaults.
+	t.Setenv("SPOTIFY_CLIENT_SECRET", "env-secret")
+	t.Setenv("BEATLES_CREDENTIALS", path)
+	settings, err := LoadAuthSettings("")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := AuthSettings{
+		SpotifyClientId:     "file-id",
+		SpotifyClientSecret: "env-secret",
+		SpotifyRedirectUrl:  "http://localhost:8080/callback",
+		CallbackAddress:     DefaultCallbackAddress,
+		path:                path,
+	}
+	if *settings != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *settings)
+	}
+
+	// A path that's given wins over BEATLES_CREDENTIALS, and needn't exist.
+	settings, err = LoadAuthSettings(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if settings.SpotifyClientId != "" || settings.SpotifyClientSecret != "env-secret" || settings.SpotifyRedirectUrl != DefaultSpotifyRedirectUrl {
+		t.Fatalf("expected only the environment and defaults, got %+v", *settings)
+	}
+
+	err = settings.Require()
+	if err == nil || !strings.Contains(err.Error(), "SPOTIFY_CLIENT_ID (or spotify_client_id in") || strings.Contains(err.Error(), "SECRET") {
+		t.Fatalf("expected only the client ID to be asked for, got %v", err)
+	}
+}
+
+func TestLoadAuthSettingsInvalid(t *testing.T) {
+	useAuthEnvironment(t)
+
+	path := filepath.Join(t.TempDir(), DefaultCredentialsFile)
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := LoadAuthSettings(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("expected the file to be named, got %v", err)
+	}
+}
@@ -1,87 +1,314 @@
-package main
+package beatles
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"net/http"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 
 	mapset "github.com/deckarep/golang-set"
 
 	"github.com/zmb3/spotify"
 )
 
-var (
-	authenticator = spotify.NewAuthenticator(spotifyRedirectUrl, spotify.ScopePlaylistModifyPrivate, spotify.ScopePlaylistModifyPublic, spotify.ScopeUserLibraryModify, spotify.ScopeUserReadPrivate)
-	clientChannel = make(chan *spotify.Client)
-)
+var spotifyScopes = []string{spotify.ScopePlaylistModifyPrivate, spotify.ScopePlaylistModifyPublic, spotify.ScopeUserLibraryModify, spotify.ScopeUserReadPrivate}
+
+// The subset of *spotify.Client that's actually used, so that something other
+// than the real service can stand in for it.
+type SpotifyClient interface {
+	CurrentUser() (*spotify.PrivateUser, error)
+	GetArtist(id spotify.ID) (*spotify.FullArtist, error)
+	GetArtistAlbumsOpt(id spotify.ID, options *spotify.Options, albumType *spotify.AlbumType) (*spotify.SimpleAlbumPage, error)
+	GetAlbum(id spotify.ID) (*spotify.FullAlbum, error)
+	GetAlbums(ids ...spotify.ID) ([]*spotify.FullAlbum, error)
+	GetAlbumTracksOpt(id spotify.ID, limit, offset int) (*spotify.SimpleTrackPage, error)
+	GetTracks(ids ...spotify.ID) ([]*spotify.FullTrack, error)
+	GetPlaylistsForUserOpt(user string, options *spotify.Options) (*spotify.SimplePlaylistPage, error)
+	GetPlaylistTracksOpt(id spotify.ID, options *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error)
+	CreatePlaylistForUser(user, name, description string, public bool) (*spotify.FullPlaylist, error)
+	AddTracksToPlaylist(id spotify.ID, ids ...spotify.ID) (string, error)
+	RemoveTracksFromPlaylist(id spotify.ID, ids ...spotify.ID) (string, error)
+	ReplacePlaylistTracks(id spotify.ID, ids ...spotify.ID) error
+}
 
-func AuthenticateSpotify() (spotifyClient *spotify.Client, err error) {
-	var tokens = ReadTokens()
+var _ SpotifyClient = (*spotify.Client)(nil)
 
-	log.Printf("Authenticating with Spotify...")
+// Most tracks added, removed or replaced in one request.
+const PlaylistWriteBatch = 100
+
+// Paces and retries every request made to Spotify.
+var spotifyLimiter = NewRetryTransport(http.DefaultTransport)
+
+// The transport beneath every authenticated Spotify client.
+var spotifyTransport http.RoundTripper = spotifyLimiter
+
+func SetRequestsPerSecond(rps float64) {
+	spotifyLimiter.RequestsPerSecond = rps
+}
 
-	if tokens.Spotify.AccessToken == "" {
-		http.HandleFunc("/spotify/callback", CompleteAuth)
-		go http.ListenAndServe(":9090", nil)
+func SetRequestTimeout(timeout time.Duration) {
+	spotifyLimiter.Timeout = timeout
+}
+
+var spotifyAccountsUrl = "https://accounts.spotify.com"
 
-		authenticator.SetAuthInfo(spotifyClientId, spotifyClientSecret)
+const spotifyApiHost = "api.spotify.com"
 
-		url := authenticator.AuthURL(spotifyOauthStateString)
-		log.Println("Please log in to Spotify by visiting the following page in your browser:", url)
+// Sends Web API requests to another host, zmb3/spotify has no way of changing
+// the address it uses.
+type rewriteTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
 
-		spotifyClient = <-clientChannel
-	} else {
-		var oauthToken oauth2.Token
-		oauthToken.AccessToken = tokens.Spotify.AccessToken
-		oauthToken.RefreshToken = tokens.Spotify.RefreshToken
-		oauthToken.Expiry, _ = time.Parse("Mon Jan 2 15:04:05 -0700 MST 2006", tokens.Spotify.Expiry)
-		oauthToken.TokenType = tokens.Spotify.TokenType
-		newClient := authenticator.NewClient(&oauthToken)
-		authenticator.SetAuthInfo(spotifyClientId, spotifyClientSecret)
-		spotifyClient = &newClient
+func (rt *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != spotifyApiHost {
+		return rt.base.RoundTrip(req)
 	}
 
-	user, err := spotifyClient.CurrentUser()
+	rewritten := *req.URL
+	rewritten.Scheme = rt.target.Scheme
+	rewritten.Host = rt.target.Host
+
+	outgoing := new(http.Request)
+	*outgoing = *req
+	outgoing.URL = &rewritten
+	outgoing.Host = ""
+
+	return rt.base.RoundTrip(outgoing)
+}
+
+// Points the Web API and accounts service at another server, a fake-server
+// for example.
+func SetSpotifyUrl(base string) error {
+	target, err := url.Parse(base)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return fmt.Errorf("Invalid Spotify URL '%s'", base)
+	}
+
+	spotifyAccountsUrl = strings.TrimRight(base, "/")
+	spotifyTransport = &rewriteTransport{
+		target: target,
+		base:   spotifyTransport,
+	}
+
+	log.Printf("Using Spotify at %s", base)
+
+	return nil
+}
+
+func spotifyOauthConfig(settings *AuthSettings) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     settings.SpotifyClientId,
+		ClientSecret: settings.SpotifyClientSecret,
+		RedirectURL:  settings.SpotifyRedirectUrl,
+		Scopes:       spotifyScopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotifyAccountsUrl + "/authorize",
+			TokenURL: spotifyAccountsUrl + "/api/token",
+		},
+	}
+}
+
+func spotifyContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: &contextTransport{ctx: ctx, base: spotifyTransport}})
+}
+
+// Every request the client makes, token refreshes included, is abandoned
+// once ctx is done. Refreshed tokens are saved to the token store.
+func NewSpotifyClient(ctx context.Context, settings *AuthSettings, token *oauth2.Token) *spotify.Client {
+	source := &savingTokenSource{
+		base: spotifyOauthConfig(settings).TokenSource(spotifyContext(ctx), token),
+		last: token,
+	}
+	client := spotify.NewClient(oauth2.NewClient(spotifyContext(ctx), source))
+	return &client
+}
+
+// Saves every token that differs from the last, which is every refresh.
+// Failing to save is only logged, the refreshed token still works for this
+// run.
+type savingTokenSource struct {
+	base oauth2.TokenSource
+	lock sync.Mutex
+	last *oauth2.Token
+}
+
+func (sts *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := sts.base.Token()
 	if err != nil {
-		return nil, fmt.Errorf("%v", err)
+		return nil, err
 	}
 
-	log.Println("spotify: You are logged in as", user.ID)
+	sts.lock.Lock()
+	defer sts.lock.Unlock()
 
-	return
+	if sts.last != nil && sts.last.AccessToken == token.AccessToken {
+		return token, nil
+	}
+
+	log.Printf("Refreshed Spotify token, expires %v", token.Expiry.Format(time.RFC3339))
+
+	if err := saveSpotifyToken(token); err != nil {
+		log.Printf("Error saving refreshed token: %v", err)
+	}
+
+	sts.last = token
+
+	return token, nil
+}
+
+func saveSpotifyToken(token *oauth2.Token) error {
+	tokens, err := ReadTokens()
+	if err != nil {
+		return err
+	}
+
+	saved := NewProviderTokens(token)
+	if previous := tokens.Provider(SpotifyProvider); previous != nil && saved.Scope == "" {
+		saved.Scope = previous.Scope
+	}
+
+	tokens.SetProvider(SpotifyProvider, saved)
+
+	return WriteTokens(tokens)
+}
+
+// A refresh token that's been revoked, or has expired, fails the refresh with
+// invalid_grant. An access token Spotify no longer accepts is a 401.
+func tokenRevoked(err error) bool {
+	var retrieveError *oauth2.RetrieveError
+	if errors.As(err, &retrieveError) {
+		return strings.Contains(string(retrieveError.Body), "invalid_grant")
+	}
+
+	var spotifyError spotify.Error
+	if errors.As(err, &spotifyError) {
+		return spotifyError.Status == http.StatusUnauthorized
+	}
+
+	return false
+}
+
+// Authorizes as the app alone, with the client credentials flow. That's
+// enough for the catalog, artists, albums and tracks, but not for anything
+// belonging to a user. There's no falling back to a user's token, playlists
+// have to come from the cache.
+func NewAppClient(ctx context.Context) (*spotify.Client, error) {
+	settings, err := CurrentAuthSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := settings.Require(); err != nil {
+		return nil, err
+	}
+
+	config := &clientcredentials.Config{
+		ClientID:     settings.SpotifyClientId,
+		ClientSecret: settings.SpotifyClientSecret,
+		TokenURL:     spotifyAccountsUrl + "/api/token",
+	}
+
+	log.Printf("Authenticating with Spotify as the app only...")
+
+	// The source reuses its token, fetching it here fails early without
+	// fetching it again on the first request.
+	source := config.TokenSource(spotifyContext(ctx))
+	if _, err := source.Token(); err != nil {
+		return nil, fmt.Errorf("Error getting app token: %v", err)
+	}
+
+	client := spotify.NewClient(oauth2.NewClient(spotifyContext(ctx), source))
+	return &client, nil
 }
 
-func CompleteAuth(w http.ResponseWriter, r *http.Request) {
-	token, err := authenticator.Token(spotifyOauthStateString, r)
+// Uses the saved token when there is one, logging in again when Spotify no
+// longer accepts it.
+func AuthenticateSpotify(ctx context.Context, options LoginOptions) (*spotify.Client, error) {
+	settings, err := CurrentAuthSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := settings.Require(); err != nil {
+		return nil, err
+	}
+
+	tokens, err := ReadTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Authenticating with Spotify...")
+
+	if saved := tokens.Provider(SpotifyProvider); saved != nil {
+		token, err := saved.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		spotifyClient := NewSpotifyClient(ctx, settings, token)
+		user, err := spotifyClient.CurrentUser()
+		if err == nil {
+			log.Println("spotify: You are logged in as", user.ID)
+			return spotifyClient, nil
+		}
+
+		if !tokenRevoked(err) {
+			return nil, fmt.Errorf("Error getting current user: %v", err)
+		}
+
+		log.Printf("Spotify no longer accepts the saved token, logging in again")
+
+		tokens.RemoveProvider(SpotifyProvider)
+		if err := WriteTokens(tokens); err != nil {
+			return nil, err
+		}
+	}
+
+	login, err := NewSpotifyLogin(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	visit := printLoginUrl
+	if options.Pasted != nil {
+		visit = printPasteLoginUrl
+	}
+
+	token, err := login.ServeCallback(ctx, options, visit)
 	if err != nil {
-		http.Error(w, "Unable to get token", http.StatusForbidden)
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if actualState := r.FormValue("state"); actualState != spotifyOauthStateString {
-		http.NotFound(w, r)
-		log.Fatalf("State mismatch: %s != %s\n", actualState, spotifyOauthStateString)
+	if err := saveSpotifyToken(token); err != nil {
+		return nil, err
+	}
+
+	spotifyClient := NewSpotifyClient(ctx, settings, token)
+	user, err := spotifyClient.CurrentUser()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting current user: %v", err)
 	}
 
-	var tokens = ReadTokens()
-	tokens.Spotify.AccessToken = token.AccessToken
-	tokens.Spotify.RefreshToken = token.RefreshToken
-	tokens.Spotify.Expiry = token.Expiry.Format("Mon Jan 2 15:04:05 -0700 MST 2006")
-	tokens.Spotify.TokenType = token.TokenType
-	WriteTokens(tokens)
+	log.Println("spotify: You are logged in as", user.ID)
 
-	client := authenticator.NewClient(token)
-	clientChannel <- &client
+	return spotifyClient, nil
 }
 
-func GetPlaylistByTitle(spotifyClient *spotify.Client, user, name string) (*spotify.SimplePlaylist, error) {
+func GetPlaylistByTitle(spotifyClient SpotifyClient, user, name string) (*spotify.SimplePlaylist, error) {
 	limit := 20
 	offset := 0
 	options := spotify.Options{Limit: &limit, Offset: &offset}
@@ -108,7 +335,7 @@ func GetPlaylistByTitle(spotifyClient *spotify.Client, user, name string) (*spot
 	return nil, nil
 }
 
-func GetPlaylist(spotifyClient *spotify.Client, user string, name string) (pl *spotify.SimplePlaylist, err error) {
+func GetPlaylist(spotifyClient SpotifyClient, user string, name string) (pl *spotify.SimplePlaylist, err error) {
 	pl, err = GetPlaylistByTitle(spotifyClient, user, name)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting '%s': %v", name, err)
@@ -121,10 +348,8 @@ func GetPlaylist(spotifyClient *spotify.Client, user string, name string) (pl *s
 
 		log.Printf("Created playlist: %v", created)
 
-		pl, err = GetPlaylistByTitle(spotifyClient, user, name)
-		if err != nil {
-			return nil, fmt.Errorf("Error getting %s: %v", name, err)
-		}
+		// Listings can lag behind a create, so it's not looked up again.
+		pl = &created.SimplePlaylist
 	}
 
 	return pl, nil
@@ -172,7 +397,7 @@ func (pu *PlaylistUpdate) Contains(id spotify.ID) bool {
 	return pu.idsBefore.Contains(id)
 }
 
-func GetArtistAlbums(spotifyClient *spotify.Client, id spotify.ID) ([]spotify.SimpleAlbum, error) {
+func GetArtistAlbums(spotifyClient SpotifyClient, id spotify.ID) ([]spotify.SimpleAlbum, error) {
 	all := make([]spotify.SimpleAlbum, 0)
 	limit := 20
 	offset := 0
@@ -196,7 +421,7 @@ func GetArtistAlbums(spotifyClient *spotify.Client, id spotify.ID) ([]spotify.Si
 	return all, nil
 }
 
-func GetAlbumTracks(spotifyClient *spotify.Client, id spotify.ID) ([]spotify.SimpleTrack, error) {
+func GetAlbumTracks(spotifyClient SpotifyClient, id spotify.ID) ([]spotify.SimpleTrack, error) {
 	all := make([]spotify.SimpleTrack, 0)
 	limit := 20
 	offset := 0
@@ -218,7 +443,7 @@ func GetAlbumTracks(spotifyClient *spotify.Client, id spotify.ID) ([]spotify.Sim
 	return all, nil
 }
 
-func GetPlaylistTracks(spotifyClient *spotify.Client, id spotify.ID) ([]spotify.PlaylistTrack, error) {
+func GetPlaylistTracks(spotifyClient SpotifyClient, id spotify.ID) ([]spotify.PlaylistTrack, error) {
 	all := make([]spotify.PlaylistTrack, 0)
 	limit := 100
 	offset := 0
@@ -242,15 +467,6 @@ func GetPlaylistTracks(spotifyClient *spotify.Client, id spotify.ID) ([]spotify.
 	return all, nil
 }
 
-func RemoveAllPlaylistTracks(spotifyClient *spotify.Client, id spotify.ID) error {
-	tracks, err := GetPlaylistTracks(spotifyClient, id)
-	if err != nil {
-		return err
-	}
-
-	return RemoveTracksFromPlaylist(spotifyClient, id, GetTrackIdsFromPlaylistTracks(tracks))
-}
-
 type TracksSet struct {
 	Ordered []spotify.ID
 	Ids     map[spotify.ID]bool
@@ -364,9 +580,9 @@ func (ts *TracksSet) Sample(number int) (ns *TracksSet) {
 	}
 }
 
-func RemoveTracksFromPlaylist(spotifyClient *spotify.Client, id spotify.ID, ids []spotify.ID) (err error) {
-	for i := 0; i < len(ids); i += 50 {
-		batch := ids[i:min(i+50, len(ids))]
+func RemoveTracksFromPlaylist(spotifyClient SpotifyClient, id spotify.ID, ids []spotify.ID) (err error) {
+	for i := 0; i < len(ids); i += PlaylistWriteBatch {
+		batch := ids[i:min(i+PlaylistWriteBatch, len(ids))]
 		_, err := spotifyClient.RemoveTracksFromPlaylist(id, batch...)
 		if err != nil {
 			return fmt.Errorf("Error removing tracks: %v", err)
@@ -376,9 +592,9 @@ func RemoveTracksFromPlaylist(spotifyClient *spotify.Client, id spotify.ID, ids
 	return nil
 }
 
-func AddTracksToPlaylist(spotifyClient *spotify.Client, id spotify.ID, ids []spotify.ID) (err error) {
-	for i := 0; i < len(ids); i += 50 {
-		batch := ids[i:min(i+50, len(ids))]
+func AddTracksToPlaylist(spotifyClient SpotifyClient, id spotify.ID, ids []spotify.ID) (err error) {
+	for i := 0; i < len(ids); i += PlaylistWriteBatch {
+		batch := ids[i:min(i+PlaylistWriteBatch, len(ids))]
 		_, err := spotifyClient.AddTracksToPlaylist(id, batch...)
 		if err != nil {
 			return fmt.Errorf("Error adding tracks: %v", err)
@@ -388,11 +604,11 @@ func AddTracksToPlaylist(spotifyClient *spotify.Client, id spotify.ID, ids []spo
 	return nil
 }
 
-func RemoveTracksSetFromPlaylist(spotifyClient *spotify.Client, id spotify.ID, ts *TracksSet) (err error) {
+func RemoveTracksSetFromPlaylist(spotifyClient SpotifyClient, id spotify.ID, ts *TracksSet) (err error) {
 	return RemoveTracksFromPlaylist(spotifyClient, id, ts.ToArray())
 }
 
-func AddTracksSetToPlaylist(spotifyClient *spotify.Client, id spotify.ID, ts *TracksSet) (err error) {
+func AddTracksSetToPlaylist(spotifyClient SpotifyClient, id spotify.ID, ts *TracksSet) (err error) {
 	return AddTracksToPlaylist(spotifyClient, id, ts.ToArray())
 }
 
@@ -404,9 +620,10 @@ func min(a, b int) int {
 }
 
 type Playlist struct {
-	ID   spotify.ID
-	User string
-	Name string
+	ID         spotify.ID
+	User       string
+	Name       string
+	SnapshotID string
 }
 
 type Track struct {
@@ -492,42 +709,47 @@ func MapIds(ids []spotify.ID) (ifaces []interface{}) {
 	return
 }
 
-func MaybeSetPlaylistTracksByName(spotifyClient *spotify.Client, readOnly bool, userName, playlistName string, tracks []spotify.ID) error {
-	log.Printf("Setting %v tracks on '%s'", len(tracks), playlistName)
-
-	if readOnly {
-		return nil
-	}
-
-	playlist, err := GetPlaylist(spotifyClient, userName, playlistName)
+// Replaces the first batch, which clears the rest of the playlist in the same
+// request, and then appends the others. The playlist is never left empty and
+// an interruption leaves a prefix of tracks that ResumePlaylistTracks can
+// continue from.
+func SetPlaylistTracks(spotifyClient SpotifyClient, id spotify.ID, tracks []spotify.ID) error {
+	batch := tracks[:min(PlaylistWriteBatch, len(tracks))]
+	err := spotifyClient.ReplacePlaylistTracks(id, batch...)
 	if err != nil {
-		return err
+		return fmt.Errorf("Error replacing tracks: %v", err)
 	}
 
-	return SetPlaylistTracks(spotifyClient, playlist.ID, tracks)
+	return AddTracksToPlaylist(spotifyClient, id, tracks[len(batch):])
 }
 
-func SetPlaylistTracksByName(spotifyClient *spotify.Client, userName, playlistName string, tracks []spotify.ID) error {
-	log.Printf("Setting %v tracks on '%s'", len(tracks), playlistName)
-
-	playlist, err := GetPlaylist(spotifyClient, userName, playlistName)
+// Finishes an interrupted SetPlaylistTracks, adding whatever's missing when
+// the playlist holds a prefix of tracks and starting over otherwise.
+func ResumePlaylistTracks(spotifyClient SpotifyClient, id spotify.ID, tracks []spotify.ID) error {
+	existing, err := GetPlaylistTracks(spotifyClient, id)
 	if err != nil {
-		return err
+		return fmt.Errorf("Error getting tracks: %v", err)
 	}
 
-	return SetPlaylistTracks(spotifyClient, playlist.ID, tracks)
+	written := GetTrackIdsFromPlaylistTracks(existing)
+	if !isPrefix(written, tracks) {
+		log.Printf("Playlist %s doesn't hold a prefix of the tracks, starting over", id)
+		return SetPlaylistTracks(spotifyClient, id, tracks)
+	}
+
+	log.Printf("Playlist %s has %d of %d tracks, adding the rest", id, len(written), len(tracks))
+
+	return AddTracksToPlaylist(spotifyClient, id, tracks[len(written):])
 }
 
-func SetPlaylistTracks(spotifyClient *spotify.Client, id spotify.ID, tracks []spotify.ID) error {
-	err := RemoveAllPlaylistTracks(spotifyClient, id)
-	if err != nil {
-		return fmt.Errorf("Error getting removing tracks: %v", err)
+func isPrefix(prefix, ids []spotify.ID) bool {
+	if len(prefix) > len(ids) {
+		return false
 	}
-
-	err = AddTracksToPlaylist(spotifyClient, id, tracks)
-	if err != nil {
-		return fmt.Errorf("Error adding tracks: %v", err)
+	for i, id := range prefix {
+		if ids[i] != id {
+			return false
+		}
 	}
-
-	return err
+	return true
 }
@@ -0,0 +1,182 @@
+package beatles
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+func TestNewAppClient(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	artist := fake.AddArtist("The Testers")
+
+	var lock sync.Mutex
+	tokens := 0
+	fss := &FakeSpotifyServer{Fake: fake}
+	useFakeSpotify(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/token" {
+			lock.Lock()
+			tokens += 1
+			lock.Unlock()
+		}
+		fss.ServeHTTP(w, r)
+	}))
+
+	client, err := NewAppClient(context.Background())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetArtist(artist.ID); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if tokens != 1 {
+		t.Fatalf("expected the app token to be fetched once, got %d", tokens)
+	}
+
+	// Playlists belong to a user, the app can't read them.
+	_, err = client.GetPlaylistsForUserOpt("tester", nil)
+	var spotifyErr spotify.Error
+	if !errors.As(err, &spotifyErr) || spotifyErr.Status != http.StatusUnauthorized {
+		t.Fatalf("expected playlists to be refused, got %v", err)
+	}
+}
+
+// Lists playlists as they were before any were created through it.
+type laggingClient struct {
+	SpotifyClient
+	listed map[spotify.ID]bool
+}
+
+func (lc *laggingClient) GetPlaylistsForUserOpt(user string, options *spotify.Options) (*spotify.SimplePlaylistPage, error) {
+	page, err := lc.SpotifyClient.GetPlaylistsForUserOpt(user, options)
+	if err != nil {
+		return nil, err
+	}
+	playlists := make([]spotify.SimplePlaylist, 0)
+	for _, playlist := range page.Playlists {
+		if lc.listed[playlist.ID] {
+			playlists = append(playlists, playlist)
+		}
+	}
+	page.Playlists = playlists
+	return page, nil
+}
+
+func TestGetPlaylistCreated(t *testing.T) {
+	fake := NewFakeSpotify("tester")
+	client := &laggingClient{SpotifyClient: fake, listed: make(map[spotify.ID]bool)}
+
+	playlist, err := GetPlaylist(client, "tester", "Created")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if playlist == nil || playlist.ID != fake.GetPlaylistByName("tester", "Created").ID {
+		t.Fatalf("expected the created playlist, got %v", playlist)
+	}
+	if playlist.Owner.ID != "tester" {
+		t.Fatalf("expected the created playlist to be the user's, got %s", playlist.Owner.ID)
+	}
+
+	// Nor does a sync trip over it.
+	plan := PlaylistPlan{Name: "Synced", Tracks: []spotify.ID{}}
+	if err := syncPlaylist(client, "tester", plan, NewSyncJournal("")); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// Saves a Spotify token that's expired, so the first request refreshes it.
+func saveExpiredToken(t *testing.T) *oauth2.Token {
+	t.Helper()
+
+	token := &oauth2.Token{
+		AccessToken:  "expired-access-token",
+		RefreshToken: "fake-refresh-token",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+	if err := saveSpotifyToken(token); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return token
+}
+
+func TestSavingTokenSource(t *testing.T) {
+	useFakeSpotify(t, &FakeSpotifyServer{Fake: NewFakeSpotify("tester")})
+	useTokensFile(t, nil)
+	token := saveExpiredToken(t)
+
+	settings, err := CurrentAuthSettings()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	client := NewSpotifyClient(context.Background(), settings, token)
+	for i := 0; i < 2; i++ {
+		if _, err := client.CurrentUser(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	tokens, err := ReadTokens()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	saved := tokens.Provider(SpotifyProvider)
+	if saved == nil || saved.AccessToken != FakeAccessToken+"-1" {
+		t.Fatalf("expected the refreshed token saved once, got %+v", saved)
+	}
+	refreshed, err := saved.Token()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !refreshed.Valid() || refreshed.RefreshToken != "fake-refresh-token" {
+		t.Fatalf("expected a valid token that can be refreshed again, got %+v", refreshed)
+	}
+}
+
+func TestAuthenticateSpotifyRevoked(t *testing.T) {
+	useFakeSpotify(t, &FakeSpotifyServer{Fake: NewFakeSpotify("tester"), RevokeRefreshTokens: true})
+	useTokensFile(t, nil)
+	saveExpiredToken(t)
+
+	// The saved token's given up on and a login started, which nobody
+	// finishes here.
+	_, err := AuthenticateSpotify(context.Background(), LoginOptions{Timeout: 100 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Fatalf("expected a login to be started, got %v", err)
+	}
+
+	tokens, err := ReadTokens()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if tokens.Provider(SpotifyProvider) != nil {
+		t.Fatalf("expected the revoked token to be removed")
+	}
+}
+
+func TestTokenRevoked(t *testing.T) {
+	for _, test := range []struct {
+		err     error
+		revoked bool
+	}{
+		{&oauth2.RetrieveError{Body: []byte(`{"error":"invalid_grant"}`)}, true},
+		{&oauth2.RetrieveError{Body: []byte(`{"error":"server_error"}`)}, false},
+		{spotify.Error{Status: http.StatusUnauthorized}, true},
+		{spotify.Error{Status: http.StatusTooManyRequests}, false},
+		{errors.New("connection reset"), false},
+	} {
+		if tokenRevoked(test.err) != test.revoked {
+			t.Errorf("tokenRevoked(%v) should be %v", test.err, test.revoked)
+		}
+	}
+}
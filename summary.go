@@ -0,0 +1,82 @@
+package beatles
+
+import (
+	"fmt"
+	"log"
+)
+
+// A unit of work, an album or a playlist say, that failed without stopping
+// the units around it.
+type Failure struct {
+	Stage string
+	Unit  string
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("%s: %s: %v", f.Stage, f.Unit, f.Err)
+}
+
+// What succeeded and what failed over a run, stages record into it as they
+// go and keep going.
+type Summary struct {
+	Succeeded []string
+	Failures  []*Failure
+	// Neither success nor failure, where data came from when it wasn't fresh say.
+	Notes []string
+}
+
+func NewSummary() *Summary {
+	return &Summary{
+		Succeeded: make([]string, 0),
+		Failures:  make([]*Failure, 0),
+		Notes:     make([]string, 0),
+	}
+}
+
+func (s *Summary) Succeed(stage, unit string) {
+	s.Succeeded = append(s.Succeeded, stage+": "+unit)
+}
+
+func (s *Summary) Fail(stage, unit string, err error) {
+	failure := &Failure{
+		Stage: stage,
+		Unit:  unit,
+		Err:   err,
+	}
+	log.Printf("Error: %v", failure)
+	s.Failures = append(s.Failures, failure)
+}
+
+func (s *Summary) Note(stage, unit, note string) {
+	note = fmt.Sprintf("%s: %s: %s", stage, unit, note)
+	log.Printf("Note: %s", note)
+	s.Notes = append(s.Notes, note)
+}
+
+func (s *Summary) Failed(stage string) bool {
+	for _, failure := range s.Failures {
+		if stage == "" || failure.Stage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Summary) Log() {
+	log.Printf("%d succeeded, %d failed", len(s.Succeeded), len(s.Failures))
+	for _, failure := range s.Failures {
+		log.Printf("  FAILED %v", failure)
+	}
+	for _, note := range s.Notes {
+		log.Printf("  NOTE %s", note)
+	}
+}
+
+// Nil unless something failed.
+func (s *Summary) Err() error {
+	if len(s.Failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d failed", len(s.Failures), len(s.Failures)+len(s.Succeeded))
+}
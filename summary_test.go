@@ -0,0 +1,45 @@
+package beatles
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	summary := NewSummary()
+	summary.Succeed("fetch", "Please")
+	if summary.Failed("") || summary.Err() != nil {
+		t.Fatalf("expected nothing to have failed, got %v", summary.Err())
+	}
+
+	// A failure's kept and the run goes on.
+	summary.Fail("fetch", "Again", errors.New("not found"))
+	summary.Succeed("sync", "The Testers (R >= 3)")
+	summary.Note("fetch", "exclusion playlists", "from the cache")
+
+	if !summary.Failed("") || !summary.Failed("fetch") || summary.Failed("sync") {
+		t.Fatalf("expected only fetch to have failed, got %v", summary.Failures)
+	}
+	if err := summary.Err(); err == nil || err.Error() != "1 of 3 failed" {
+		t.Fatalf("expected 1 of 3 failed, got %v", err)
+	}
+	if failure := summary.Failures[0].Error(); failure != "fetch: Again: not found" {
+		t.Fatalf("expected the stage and unit, got %s", failure)
+	}
+
+	logged.Reset()
+	summary.Log()
+	for _, expected := range []string{"2 succeeded, 1 failed", "FAILED fetch: Again: not found", "NOTE fetch: exclusion playlists: from the cache"} {
+		if !strings.Contains(logged.String(), expected) {
+			t.Errorf("expected '%s' logged, got %s", expected, logged.String())
+		}
+	}
+}
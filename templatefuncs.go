@@ -0,0 +1,144 @@
+package beatles
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// Functions for the org and HTML report templates. Those taking a list take
+// it last, so they can end a pipeline: {{.ByName | sortBy "-Popularity"}}.
+var ReportFuncs = map[string]interface{}{
+	"duration":           formatDuration,
+	"date":               formatDate,
+	"spotifyTrackUrl":    spotifyUrl("track"),
+	"spotifyAlbumUrl":    spotifyUrl("album"),
+	"spotifyArtistUrl":   spotifyUrl("artist"),
+	"spotifyPlaylistUrl": spotifyUrl("playlist"),
+	"join":               join,
+	"pluralize":          pluralize,
+	"groupBy":            groupTracksBy,
+	"sortBy":             sortTracksBy,
+}
+
+// Milliseconds as m:ss, or h:mm:ss from an hour up.
+func formatDuration(ms int) string {
+	seconds := ms / 1000
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Formats with the layout given, 2006-01-02 otherwise. Nothing for the zero
+// time.
+func formatDate(t time.Time, layout ...string) string {
+	if t.IsZero() {
+		return ""
+	}
+	if len(layout) > 0 {
+		return t.Format(layout[0])
+	}
+	return t.Format("2006-01-02")
+}
+
+func spotifyUrl(kind string) func(id spotify.ID) string {
+	return func(id spotify.ID) string {
+		return fmt.Sprintf("https://open.spotify.com/%s/%s", kind, id)
+	}
+}
+
+func join(separator string, items []string) string {
+	return strings.Join(items, separator)
+}
+
+// "1 track", "2 tracks", or with the plural given, "2 albums (or more)" say.
+func pluralize(n int, singular string, plural ...string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	if len(plural) > 0 {
+		return fmt.Sprintf("%d %s", n, plural[0])
+	}
+	return fmt.Sprintf("%d %ss", n, singular)
+}
+
+// Tracks sharing a field's value, in the order the values are first seen.
+type TrackGroup struct {
+	Key    string
+	Tracks []*TrackInfo
+}
+
+func trackField(field string) (func(track *TrackInfo) reflect.Value, error) {
+	f, ok := reflect.TypeOf(TrackInfo{}).FieldByName(field)
+	if !ok {
+		return nil, fmt.Errorf("TrackInfo has no field %s", field)
+	}
+	return func(track *TrackInfo) reflect.Value {
+		return reflect.ValueOf(track).Elem().FieldByIndex(f.Index)
+	}, nil
+}
+
+func groupTracksBy(field string, tracks []*TrackInfo) ([]*TrackGroup, error) {
+	value, err := trackField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*TrackGroup, 0)
+	byKey := make(map[string]*TrackGroup)
+	for _, track := range tracks {
+		key := fmt.Sprint(value(track).Interface())
+		group, ok := byKey[key]
+		if !ok {
+			group = &TrackGroup{Key: key}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Tracks = append(group.Tracks, track)
+	}
+
+	return groups, nil
+}
+
+// A sorted copy, by a field of TrackInfo and descending when it starts with
+// a -. Ties keep their order.
+func sortTracksBy(field string, tracks []*TrackInfo) ([]*TrackInfo, error) {
+	descending := strings.HasPrefix(field, "-")
+
+	value, err := trackField(strings.TrimPrefix(field, "-"))
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*TrackInfo, len(tracks))
+	copy(sorted, tracks)
+
+	less := func(a, b reflect.Value) bool {
+		switch a.Kind() {
+		case reflect.Int:
+			return a.Int() < b.Int()
+		case reflect.String:
+			return a.String() < b.String()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		}
+		if t, ok := a.Interface().(time.Time); ok {
+			return t.Before(b.Interface().(time.Time))
+		}
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return less(value(sorted[j]), value(sorted[i]))
+		}
+		return less(value(sorted[i]), value(sorted[j]))
+	})
+
+	return sorted, nil
+}
@@ -0,0 +1,143 @@
+package beatles
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	for _, test := range []struct {
+		ms       int
+		expected string
+	}{
+		{0, "0:00"},
+		{61 * 1000, "1:01"},
+		{3599 * 1000, "59:59"},
+		{3600 * 1000, "1:00:00"},
+		{3661*1000 + 999, "1:01:01"},
+	} {
+		if actual := formatDuration(test.ms); actual != test.expected {
+			t.Errorf("formatDuration(%d) should be %s, got %s", test.ms, test.expected, actual)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	date := time.Date(1965, 8, 6, 0, 0, 0, 0, time.UTC)
+	if actual := formatDate(date); actual != "1965-08-06" {
+		t.Errorf("expected 1965-08-06, got %s", actual)
+	}
+	if actual := formatDate(date, "2006"); actual != "1965" {
+		t.Errorf("expected 1965, got %s", actual)
+	}
+	if actual := formatDate(time.Time{}); actual != "" {
+		t.Errorf("expected nothing for the zero time, got %s", actual)
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	for _, test := range []struct {
+		n        int
+		plural   []string
+		expected string
+	}{
+		{0, nil, "0 tracks"},
+		{1, nil, "1 track"},
+		{2, nil, "2 tracks"},
+		{1, []string{"albums (or more)"}, "1 track"},
+		{3, []string{"albums (or more)"}, "3 albums (or more)"},
+	} {
+		if actual := pluralize(test.n, "track", test.plural...); actual != test.expected {
+			t.Errorf("pluralize(%d, %v) should be %s, got %s", test.n, test.plural, test.expected, actual)
+		}
+	}
+}
+
+func testTracks() []*TrackInfo {
+	return []*TrackInfo{
+		{Name: "Help", Popularity: 50, AlbumReleaseDate: time.Date(1965, 8, 6, 0, 0, 0, 0, time.UTC), Album: "Help!"},
+		{Name: "Yesterday", Popularity: 80, AlbumReleaseDate: time.Date(1965, 8, 6, 0, 0, 0, 0, time.UTC), Album: "Help!"},
+		{Name: "Twist", Popularity: 50, AlbumReleaseDate: time.Date(1963, 3, 22, 0, 0, 0, 0, time.UTC), Album: "Please"},
+		{Name: "Intro", Popularity: 10, Album: "Live"},
+	}
+}
+
+func trackNames(tracks []*TrackInfo) []string {
+	names := make([]string, 0)
+	for _, track := range tracks {
+		names = append(names, track.Name)
+	}
+	return names
+}
+
+func TestSortTracksBy(t *testing.T) {
+	for _, test := range []struct {
+		field    string
+		expected []string
+	}{
+		{"Name", []string{"Help", "Intro", "Twist", "Yesterday"}},
+		{"-Name", []string{"Yesterday", "Twist", "Intro", "Help"}},
+		// Ties keep their order, Help's ahead of Twist either way.
+		{"Popularity", []string{"Intro", "Help", "Twist", "Yesterday"}},
+		{"-Popularity", []string{"Yesterday", "Help", "Twist", "Intro"}},
+		// The zero time's earliest.
+		{"AlbumReleaseDate", []string{"Intro", "Twist", "Help", "Yesterday"}},
+		{"-AlbumReleaseDate", []string{"Help", "Yesterday", "Twist", "Intro"}},
+	} {
+		tracks := testTracks()
+		sorted, err := sortTracksBy(test.field, tracks)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if actual := trackNames(sorted); !equalStrings(actual, test.expected) {
+			t.Errorf("sortBy %s should be %v, got %v", test.field, test.expected, actual)
+		}
+		if actual := trackNames(tracks); !equalStrings(actual, trackNames(testTracks())) {
+			t.Errorf("expected sortBy %s to leave the tracks given alone, got %v", test.field, actual)
+		}
+	}
+
+	if _, err := sortTracksBy("-Loudness", testTracks()); err == nil {
+		t.Errorf("expected an unknown field to fail")
+	}
+}
+
+func TestGroupTracksBy(t *testing.T) {
+	groups, err := groupTracksBy("Album", testTracks())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []struct {
+		key    string
+		tracks []string
+	}{
+		{"Help!", []string{"Help", "Yesterday"}},
+		{"Please", []string{"Twist"}},
+		{"Live", []string{"Intro"}},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("expected %d groups, got %d", len(expected), len(groups))
+	}
+	for i, group := range groups {
+		if group.Key != expected[i].key || !equalStrings(trackNames(group.Tracks), expected[i].tracks) {
+			t.Errorf("expected group %d to be %v, got %s %v", i, expected[i], group.Key, trackNames(group.Tracks))
+		}
+	}
+
+	if _, err := groupTracksBy("Loudness", testTracks()); err == nil {
+		t.Errorf("expected an unknown field to fail")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
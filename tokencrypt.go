@@ -0,0 +1,157 @@
+package beatles
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const tokenKeyIterations = 200000
+
+// Encrypts the token store, with a key derived from a passphrase or read
+// from a key file. Nil stores tokens in the clear.
+type TokenCipher struct {
+	passphrase string
+	keyFile    string
+}
+
+// The passphrase comes from BEATLES_TOKEN_PASSPHRASE, the key file from
+// BEATLES_TOKEN_KEY_FILE unless keyFile's given. Nil, without an error, when
+// neither is set.
+func NewTokenCipher(keyFile string) (*TokenCipher, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv("BEATLES_TOKEN_KEY_FILE")
+	}
+
+	passphrase := os.Getenv("BEATLES_TOKEN_PASSPHRASE")
+
+	if keyFile != "" && passphrase != "" {
+		return nil, fmt.Errorf("Both a token key file and passphrase were given, use one")
+	}
+	if keyFile == "" && passphrase == "" {
+		return nil, nil
+	}
+
+	return &TokenCipher{passphrase: passphrase, keyFile: keyFile}, nil
+}
+
+// Encrypted token stores are this instead of the tokens themselves.
+type encryptedTokens struct {
+	Encrypted struct {
+		Kdf        string `json:"kdf"`
+		Salt       []byte `json:"salt,omitempty"`
+		Iterations int    `json:"iterations,omitempty"`
+		Nonce      []byte `json:"nonce"`
+		Ciphertext []byte `json:"ciphertext"`
+	} `json:"encrypted"`
+}
+
+func isEncryptedTokens(data []byte) bool {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	_, ok := probe["encrypted"]
+	return ok
+}
+
+func (tc *TokenCipher) key(salt []byte, iterations int) ([]byte, error) {
+	if tc.keyFile != "" {
+		data, err := ioutil.ReadFile(tc.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading token key file: %v", err)
+		}
+		if len(data) < 16 {
+			return nil, fmt.Errorf("Token key file %s is too short, use at least 16 random bytes", tc.keyFile)
+		}
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	}
+
+	key, err := pbkdf2.Key(sha256.New, tc.passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Error deriving token key: %v", err)
+	}
+	return key, nil
+}
+
+func (tc *TokenCipher) Encrypt(plain []byte) ([]byte, error) {
+	var sealed encryptedTokens
+
+	if tc.keyFile != "" {
+		sealed.Encrypted.Kdf = "key-file"
+	} else {
+		sealed.Encrypted.Kdf = "pbkdf2-sha256"
+		sealed.Encrypted.Iterations = tokenKeyIterations
+		sealed.Encrypted.Salt = make([]byte, 16)
+		if _, err := rand.Read(sealed.Encrypted.Salt); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := tc.key(sealed.Encrypted.Salt, sealed.Encrypted.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newTokenAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.Encrypted.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sealed.Encrypted.Nonce); err != nil {
+		return nil, err
+	}
+
+	sealed.Encrypted.Ciphertext = aead.Seal(nil, sealed.Encrypted.Nonce, plain, nil)
+
+	return json.Marshal(sealed)
+}
+
+func (tc *TokenCipher) Decrypt(data []byte) ([]byte, error) {
+	var sealed encryptedTokens
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case sealed.Encrypted.Kdf == "key-file" && tc.keyFile == "":
+		return nil, fmt.Errorf("The tokens were encrypted with a key file, set BEATLES_TOKEN_KEY_FILE")
+	case sealed.Encrypted.Kdf == "pbkdf2-sha256" && tc.passphrase == "":
+		return nil, fmt.Errorf("The tokens were encrypted with a passphrase, set BEATLES_TOKEN_PASSPHRASE")
+	case sealed.Encrypted.Kdf != "key-file" && sealed.Encrypted.Kdf != "pbkdf2-sha256":
+		return nil, fmt.Errorf("Unknown token encryption '%s'", sealed.Encrypted.Kdf)
+	}
+
+	key, err := tc.key(sealed.Encrypted.Salt, sealed.Encrypted.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newTokenAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, sealed.Encrypted.Nonce, sealed.Encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting tokens, wrong passphrase or key file?")
+	}
+
+	return plain, nil
+}
+
+func newTokenAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
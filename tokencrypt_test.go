@@ -0,0 +1,167 @@
+package beatles
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTokens = `{"Providers":{"spotify":{"AccessToken":"access","RefreshToken":"refresh"}}}`
+
+// Writes the tokens to a store of their own, restoring the previous one
+// afterwards.
+func useTokensFile(t *testing.T, tc *TokenCipher) string {
+	t.Helper()
+
+	previousFile, previousLegacy, previousCipher := tokensFile, legacyTokensFile, tokenCipher
+	t.Cleanup(func() {
+		SetTokensFile(previousFile, previousLegacy)
+		SetTokenCipher(previousCipher)
+	})
+
+	path := filepath.Join(t.TempDir(), "beatles", DefaultTokensFile)
+	SetTokensFile(path, "")
+	SetTokenCipher(tc)
+	return path
+}
+
+func newTestKeyFile(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "token.key")
+	if err := ioutil.WriteFile(path, []byte("0123456789abcdef0123456789abcdef"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return path
+}
+
+func TestTokenCipherRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		cipher *TokenCipher
+		kdf    string
+	}{
+		{"passphrase", &TokenCipher{passphrase: "correct horse"}, "pbkdf2-sha256"},
+		{"key file", &TokenCipher{keyFile: newTestKeyFile(t)}, "key-file"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sealed, err := test.cipher.Encrypt([]byte(testTokens))
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if !isEncryptedTokens(sealed) || bytes.Contains(sealed, []byte("refresh")) {
+				t.Fatalf("expected the tokens to be encrypted, got %s", sealed)
+			}
+
+			var probe encryptedTokens
+			if err := json.Unmarshal(sealed, &probe); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if probe.Encrypted.Kdf != test.kdf {
+				t.Fatalf("expected %s, got %s", test.kdf, probe.Encrypted.Kdf)
+			}
+
+			plain, err := test.cipher.Decrypt(sealed)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if string(plain) != testTokens {
+				t.Fatalf("expected the tokens back, got %s", plain)
+			}
+
+			// A fresh salt and nonce every time.
+			again, err := test.cipher.Encrypt([]byte(testTokens))
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if bytes.Equal(sealed, again) {
+				t.Fatalf("expected encrypting twice to differ")
+			}
+		})
+	}
+}
+
+func TestTokenCipherWrongPassphrase(t *testing.T) {
+	sealed, err := (&TokenCipher{passphrase: "correct horse"}).Encrypt([]byte(testTokens))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = (&TokenCipher{passphrase: "battery staple"}).Decrypt(sealed)
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("expected the wrong passphrase to fail, got %v", err)
+	}
+
+	// Nor will a key file do for a passphrase.
+	_, err = (&TokenCipher{keyFile: newTestKeyFile(t)}).Decrypt(sealed)
+	if err == nil || !strings.Contains(err.Error(), "BEATLES_TOKEN_PASSPHRASE") {
+		t.Fatalf("expected to be asked for the passphrase, got %v", err)
+	}
+}
+
+func TestTokenCipherTampered(t *testing.T) {
+	tc := &TokenCipher{passphrase: "correct horse"}
+	sealed, err := tc.Encrypt([]byte(testTokens))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var tampered encryptedTokens
+	if err := json.Unmarshal(sealed, &tampered); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tampered.Encrypted.Ciphertext[0] ^= 1
+	data, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := tc.Decrypt(data); err == nil {
+		t.Fatalf("expected tampered tokens not to decrypt")
+	}
+}
+
+func TestTokensFileMode(t *testing.T) {
+	path := useTokensFile(t, &TokenCipher{passphrase: "correct horse"})
+
+	tokens := &Tokens{}
+	tokens.SetProvider(SpotifyProvider, &ProviderTokens{AccessToken: "access", RefreshToken: "refresh"})
+	if err := WriteTokens(tokens); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the tokens to be 0600, got %v", info.Mode().Perm())
+	}
+	if encrypted, err := TokensEncrypted(); err != nil || !encrypted {
+		t.Fatalf("expected the tokens to be encrypted, got %v %v", encrypted, err)
+	}
+
+	// Loosened by hand, reading restricts them again.
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	SetTokensFile(path, "")
+	read, err := ReadTokens()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if read.Provider(SpotifyProvider) == nil || read.Provider(SpotifyProvider).RefreshToken != "refresh" {
+		t.Fatalf("expected the tokens back, got %+v", read)
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected reading to restrict the tokens to 0600, got %v", info.Mode().Perm())
+	}
+}
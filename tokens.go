@@ -1,50 +1,324 @@
-package main
+package beatles
 
 import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/oauth2"
 )
 
-type SpotifyTokens struct {
+// One provider's OAuth token. Expiry is RFC 3339, older tokens.json files
+// have legacyExpiryLayout, which is still read.
+type ProviderTokens struct {
 	AccessToken  string
 	RefreshToken string
 	Expiry       string
 	TokenType    string
+	Scope        string `json:",omitempty"`
 }
 
+const legacyExpiryLayout = "Mon Jan 2 15:04:05 -0700 MST 2006"
+
+func NewProviderTokens(token *oauth2.Token) *ProviderTokens {
+	return &ProviderTokens{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry.Format(time.RFC3339),
+		TokenType:    token.TokenType,
+		Scope:        tokenScope(token),
+	}
+}
+
+// The scopes granted, as the token response listed them. Refreshes don't
+// always repeat them.
+func tokenScope(token *oauth2.Token) string {
+	if scope, ok := token.Extra("scope").(string); ok {
+		return scope
+	}
+	return ""
+}
+
+func (st *ProviderTokens) Token() (*oauth2.Token, error) {
+	token := &oauth2.Token{
+		AccessToken:  st.AccessToken,
+		RefreshToken: st.RefreshToken,
+		TokenType:    st.TokenType,
+	}
+
+	if st.Expiry != "" {
+		expiry, err := time.Parse(time.RFC3339, st.Expiry)
+		if err != nil {
+			expiry, err = time.Parse(legacyExpiryLayout, st.Expiry)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing token expiry '%s': %v", st.Expiry, err)
+		}
+		token.Expiry = expiry
+	}
+
+	return token, nil
+}
+
+const SpotifyProvider = "spotify"
+
+// Tokens by provider. Older files have a Spotify field instead, that's moved
+// into Providers when read.
 type Tokens struct {
-	Facebook string
-	Spotify  SpotifyTokens
+	Providers map[string]*ProviderTokens
+	Spotify   *ProviderTokens `json:",omitempty"`
 }
 
 var globalTokens Tokens
 
-func ReadTokens() (tokens *Tokens) {
-	file, e := ioutil.ReadFile("./tokens.json")
-	if e != nil {
-		fmt.Printf("File error: %v\n", e)
-		os.Exit(1)
+// Nil unless there's a token for provider.
+func (t *Tokens) Provider(provider string) *ProviderTokens {
+	if tokens, ok := t.Providers[provider]; ok && tokens.AccessToken != "" {
+		return tokens
+	}
+	return nil
+}
+
+func (t *Tokens) SetProvider(provider string, tokens *ProviderTokens) {
+	if t.Providers == nil {
+		t.Providers = make(map[string]*ProviderTokens)
+	}
+	t.Providers[provider] = tokens
+}
+
+func (t *Tokens) RemoveProvider(provider string) {
+	delete(t.Providers, provider)
+}
+
+const DefaultTokensFile = "tokens.json"
+
+var (
+	tokensFile       = DefaultTokensFile
+	legacyTokensFile string
+	// Whose default tokens file to use when tokensFile is empty.
+	tokensProfile string
+	tokenCipher   *TokenCipher
+)
+
+// Where a profile's tokens go by default, in the user's config directory
+// rather than wherever beatles happens to be run.
+func DefaultTokensPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Error finding the config directory, give the profile a tokens file: %v", err)
+	}
+
+	file := DefaultTokensFile
+	if name != "" {
+		file = fmt.Sprintf("tokens-%s.json", name)
+	}
+
+	return filepath.Join(dir, "beatles", file), nil
+}
+
+// Switches the token store, a profile's say. legacy, if not empty, is where
+// the store used to be and is moved from when path doesn't exist yet.
+func SetTokensFile(path, legacy string) {
+	tokensFile = path
+	legacyTokensFile = legacy
+	tokensProfile = ""
+	globalTokens = Tokens{}
+}
+
+// Switches the token store to the named profile's default, which is only
+// looked for in the config directory once tokens are read or written, so
+// commands that never need them run without one.
+func SetDefaultTokensFile(name, legacy string) {
+	SetTokensFile("", legacy)
+	tokensProfile = name
+}
+
+func findTokensFile() error {
+	if tokensFile != "" {
+		return nil
+	}
+
+	path, err := DefaultTokensPath(tokensProfile)
+	if err != nil {
+		return err
+	}
+	tokensFile = path
+
+	return nil
+}
+
+// Empty until the tokens have been read or written.
+func TokensFile() string {
+	return tokensFile
+}
+
+// Encrypts tokens written from now on, nil writes them in the clear.
+func SetTokenCipher(tc *TokenCipher) {
+	tokenCipher = tc
+}
+
+// Whether the token store on disk is encrypted, false if there isn't one.
+func TokensEncrypted() (bool, error) {
+	if err := findTokensFile(); err != nil {
+		return false, err
+	}
+
+	data, err := ioutil.ReadFile(tokensFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error reading tokens: %v", err)
+	}
+	return isEncryptedTokens(data), nil
+}
+
+// Tokens written before there were profiles in the config directory are
+// moved there, encrypted if that's configured.
+func migrateLegacyTokens() error {
+	if err := findTokensFile(); err != nil {
+		return err
+	}
+
+	if legacyTokensFile == "" || legacyTokensFile == tokensFile {
+		return nil
+	}
+	if _, err := os.Stat(tokensFile); !os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(legacyTokensFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading tokens: %v", err)
+	}
+
+	if err := writeTokensFile(data); err != nil {
+		return err
+	}
+
+	if err := os.Remove(legacyTokensFile); err != nil {
+		return fmt.Errorf("Error removing %s: %v", legacyTokensFile, err)
+	}
+
+	log.Printf("Moved tokens from %s to %s", legacyTokensFile, tokensFile)
+
+	return nil
+}
+
+// A missing tokens file is the same as an empty one, it's created on first
+// login.
+func ReadTokens() (*Tokens, error) {
+	if err := migrateLegacyTokens(); err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.ReadFile(tokensFile)
+	if os.IsNotExist(err) {
+		globalTokens = Tokens{}
+		return &globalTokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading tokens: %v", err)
 	}
 
-	json.Unmarshal(file, &globalTokens)
+	if info, err := os.Stat(tokensFile); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Printf("Tokens in %s were readable by others, restricting them to you", tokensFile)
+		if err := os.Chmod(tokensFile, 0600); err != nil {
+			return nil, fmt.Errorf("Error restricting tokens: %v", err)
+		}
+	}
+
+	if isEncryptedTokens(file) {
+		if tokenCipher == nil {
+			return nil, fmt.Errorf("The tokens in %s are encrypted, set BEATLES_TOKEN_PASSPHRASE or BEATLES_TOKEN_KEY_FILE", tokensFile)
+		}
+		file, err = tokenCipher.Decrypt(file)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	tokens = &globalTokens
+	// Into tokens of its own, unmarshalling into a map keeps what's there.
+	var tokens Tokens
+	err = json.Unmarshal(file, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling tokens: %v", err)
+	}
+
+	if tokens.Spotify != nil {
+		if tokens.Provider(SpotifyProvider) == nil {
+			tokens.SetProvider(SpotifyProvider, tokens.Spotify)
+		}
+		tokens.Spotify = nil
+	}
 
-	return
+	globalTokens = tokens
+
+	return &globalTokens, nil
 }
 
-func WriteTokens(tokens *Tokens) {
-	var tokensJson, err = json.Marshal(tokens)
+func WriteTokens(tokens *Tokens) error {
+	tokensJson, err := json.Marshal(tokens)
 	if err != nil {
-		fmt.Printf("JSON error: %v\n", err)
+		return fmt.Errorf("Error marshalling tokens: %v", err)
+	}
+
+	return writeTokensFile(tokensJson)
+}
+
+// Only the user can read the tokens, or the directory they're in.
+func writeTokensFile(data []byte) error {
+	if tokenCipher != nil && !isEncryptedTokens(data) {
+		sealed, err := tokenCipher.Encrypt(data)
+		if err != nil {
+			return fmt.Errorf("Error encrypting tokens: %v", err)
+		}
+		data = sealed
+	}
 
+	if err := findTokensFile(); err != nil {
+		return err
 	}
 
-	err = ioutil.WriteFile("./tokens.json", tokensJson, 0644)
+	if err := os.MkdirAll(filepath.Dir(tokensFile), 0700); err != nil {
+		return fmt.Errorf("Error creating tokens directory: %v", err)
+	}
+
+	if err := WriteFileAtomic(tokensFile, data, 0600); err != nil {
+		return fmt.Errorf("Error writing tokens: %v", err)
+	}
+
+	return nil
+}
+
+// Forgets provider's token, removing the store once it's empty. Spotify has
+// no way to revoke a token, it's left to expire and the app can be removed
+// from the account's apps page to invalidate the refresh token.
+func RemoveTokens(provider string) (bool, error) {
+	tokens, err := ReadTokens()
 	if err != nil {
-		fmt.Printf("File error: %v\n", err)
-		os.Exit(1)
+		return false, err
 	}
+
+	if tokens.Provider(provider) == nil {
+		return false, nil
+	}
+
+	tokens.RemoveProvider(provider)
+
+	if len(tokens.Providers) == 0 {
+		if err := os.Remove(tokensFile); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("Error removing tokens: %v", err)
+		}
+		return true, nil
+	}
+
+	return true, WriteTokens(tokens)
 }
@@ -0,0 +1,93 @@
+package beatles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTokensReplaces(t *testing.T) {
+	path := useTokensFile(t, nil)
+
+	tokens := &Tokens{}
+	tokens.SetProvider(SpotifyProvider, &ProviderTokens{AccessToken: "spotify"})
+	tokens.SetProvider("other", &ProviderTokens{AccessToken: "other"})
+	if err := WriteTokens(tokens); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := ReadTokens(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Written elsewhere without the other provider, say by a logout.
+	if err := ioutil.WriteFile(path, []byte(testTokens), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	read, err := ReadTokens()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if read.Provider("other") != nil {
+		t.Fatalf("expected only the file's providers, got %v", read.Providers)
+	}
+	if read.Provider(SpotifyProvider) == nil || read.Provider(SpotifyProvider).AccessToken != "access" {
+		t.Fatalf("expected the file's Spotify token, got %+v", read.Provider(SpotifyProvider))
+	}
+}
+
+func TestReadTokensLegacy(t *testing.T) {
+	path := useTokensFile(t, nil)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("%v", err)
+	}
+	legacy := `{"Facebook":"","Spotify":{"AccessToken":"access","RefreshToken":"refresh","Expiry":"Sat Mar 2 10:04:05 +0000 UTC 2019","TokenType":"Bearer"}}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tokens, err := ReadTokens()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	saved := tokens.Provider(SpotifyProvider)
+	if saved == nil || tokens.Spotify != nil {
+		t.Fatalf("expected the Spotify field moved into providers, got %+v", tokens)
+	}
+	token, err := saved.Token()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !token.Expiry.Equal(time.Date(2019, 3, 2, 10, 4, 5, 0, time.UTC)) {
+		t.Fatalf("expected the legacy expiry read, got %v", token.Expiry)
+	}
+}
+
+func TestProfileWithoutConfigDirectory(t *testing.T) {
+	useTokensFile(t, nil)
+	useCacheDirectory(t)
+	t.Setenv("HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("BEATLES_PROFILES", filepath.Join(t.TempDir(), DefaultProfilesFile))
+
+	// Loading the profile and using its cache don't need one.
+	profile, err := LoadProfile("", "testers")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	profile.Apply()
+	if _, err := ListCacheEntries(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Only the tokens do.
+	if _, err := ReadTokens(); err == nil || !strings.Contains(err.Error(), "config directory") {
+		t.Fatalf("expected the tokens to need the config directory, got %v", err)
+	}
+	if err := WriteTokens(&Tokens{}); err == nil {
+		t.Fatalf("expected writing the tokens to need the config directory")
+	}
+}
@@ -0,0 +1,148 @@
+package beatles
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+type TrackInfo struct {
+	ID                   spotify.ID
+	Album                string
+	Name                 string
+	ShortName            string
+	AlbumReleaseDate     time.Time
+	SongReleaseDate      time.Time
+	Duration             int
+	Popularity           int
+	Dissected            []string
+	Short                bool
+	Recordings           int
+	Has3OrMoreRecordings bool
+	Has1Recording        bool
+	Guessed              bool
+	Excluded             bool
+	ExcludedReasons      []string
+	OnExcludedAlbum      bool
+	Original             bool
+}
+
+type ByName []*TrackInfo
+
+func (s ByName) Len() int {
+	return len(s)
+}
+
+func (s ByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ByName) Less(i, j int) bool {
+	if strings.Compare(s[i].Name, s[j].Name) > 0 {
+		return false
+	}
+	return true
+}
+
+type ByReleaseDate []*TrackInfo
+
+func (s ByReleaseDate) Len() int {
+	return len(s)
+}
+
+func (s ByReleaseDate) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ByReleaseDate) Less(i, j int) bool {
+	return s[i].SongReleaseDate.Unix() > s[j].SongReleaseDate.Unix()
+}
+
+type ByPopularity []*TrackInfo
+
+func (s ByPopularity) Len() int {
+	return len(s)
+}
+
+func (s ByPopularity) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ByPopularity) Less(i, j int) bool {
+	return s[i].Popularity > s[j].Popularity
+}
+
+func NewTrackInfo(albumName string, albumReleaseDate string, track spotify.FullTrack) (*TrackInfo, error) {
+	trackName := track.Name
+	trackName = strings.Replace(trackName, "U.S.S.R", "U.S.S.R.", -1)
+	trackName = strings.Replace(trackName, "Sgt.", "Sgt", -1)
+	trackName = strings.Replace(trackName, "Mr.", "Mr", -1)
+	trackName = strings.Replace(trackName, "Sixty Four", "Sixty-Four", -1)
+
+	dissected := DissectTrackName(trackName)
+	shortName := dissected[0]
+	releaseDate, err := ParseReleaseDate(albumReleaseDate)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing release date: %v", err)
+	}
+
+	return &TrackInfo{
+		ID:               track.ID,
+		Album:            albumName,
+		Name:             trackName,
+		ShortName:        shortName,
+		Duration:         track.Duration,
+		Popularity:       track.Popularity,
+		Dissected:        dissected,
+		AlbumReleaseDate: releaseDate,
+		ExcludedReasons:  make([]string, 0),
+	}, nil
+}
+
+func (ti *TrackInfo) Exclude(reason string) {
+	ti.Excluded = true
+	for _, v := range ti.ExcludedReasons {
+		if v == reason {
+			return
+		}
+	}
+	ti.ExcludedReasons = append(ti.ExcludedReasons, reason)
+}
+
+func (ti *TrackInfo) ExcludedReason() string {
+	return strings.Join(ti.ExcludedReasons, ", ")
+}
+
+// What excluded a track, its reason without the details, "Too short" for
+// "Too short (42s)" say. Exclusion playlists are each their own rule.
+func ExclusionRule(reason string) string {
+	if strings.HasPrefix(reason, "Excluded by ") {
+		return reason
+	}
+	return strings.SplitN(reason, " (", 2)[0]
+}
+
+// Older albums are often only dated to the year or month.
+func ParseReleaseDate(releaseDate string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		var parsed time.Time
+		parsed, err = time.Parse(layout, releaseDate)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func DissectTrackName(name string) []string {
+	parts := strings.Split(name, " - ")
+
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+
+	return parts
+}